@@ -0,0 +1,199 @@
+//{{{  license
+// Copyright 2018,2019 A E Lawrence
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//}}}
+
+// carve recovers GPS tracks from a raw image of a memory card, or the card
+// itself, when the files are gone: reformatted, deleted or damaged. Every
+// GPS block in the image is decoded wherever it lies, and the fixes are
+// grouped into tracks by time. Each point records its byte offset in the
+// image, so that the video around it can be looked for.
+package main
+
+//{{{  imports
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/clarified/mov2gps/go/gpx"
+	"github.com/clarified/mov2gps/go/nb"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+//}}}
+//{{{  flags
+var (
+	output	= flag.String("o", "-", "gpx file to write, '-' for stdout")
+	overwrite = flag.Bool("w", false, "Overwrite any existing gpx file")
+	gpxVersion = flag.Int("g", 1, "gpx version: 0 or 1 for 1.0 or 1.1")
+	noNMEA	= flag.Bool("x", false, "Do not use NMEA GGA records")
+	gap	= flag.Duration("gap", 10*time.Second,
+		"Start a new track after a gap in GPS time longer than this")
+	minPoints = flag.Int("min", 2, "Leave out tracks with fewer points")
+	rubbish	= flag.Bool("clean", true,
+		"Remove dubious points at sea with lat/long = 0/0")
+	verbose	= flag.Bool("v", false, "Summarise the tracks found on stderr")
+	debug	= flag.Bool("debug", false, "tracing to stderr")
+)
+//}}}
+//{{{  usage
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: carve [flags] image")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+//}}}
+
+//{{{  main
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 1 || *gpxVersion < 0 || *gpxVersion > 1 {
+		usage()
+	}
+	nb.SetDebug(*debug)
+	if err := carve(flag.Arg(0)); err != nil {
+		log.Fatal(err)
+	}
+}
+//}}}
+
+//{{{  carve(imagePath) -- scan, group and write
+func carve(imagePath string) error {
+	image, err := os.Open(imagePath)
+	if err != nil {
+		return err
+	}
+	defer image.Close()
+	//{{{  Open the output before the long scan, so mistakes show early
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		if _, err := os.Stat(*output); err == nil && !*overwrite {
+			return errors.New(fmt.Sprintf(
+				"%s: already exists. Use -w to overwrite.", *output))
+		}
+		gpxFile, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer gpxFile.Close()
+		w = gpxFile
+	}
+	//}}}
+	fixes, blocks, err := scan(image)
+	if err != nil {
+		return err
+	}
+	tracks := group(fixes)
+	//{{{  Summary
+	if *verbose || *debug {
+		fmt.Fprintf(os.Stderr, "%s: %d GPS blocks, %d fixes, %d tracks\n",
+			imagePath, blocks, len(fixes), len(tracks))
+		for _, t := range tracks {
+			first, last := t[0], t[len(t)-1]
+			fmt.Fprintf(os.Stderr, "\t%s - %s %5d points, offsets %#x - %#x\n",
+				first.Time.Format("2006-01-02 15:04:05"),
+				last.Time.Format("15:04:05"), len(t),
+				first.Offset, last.Offset)
+		}
+	}
+	//}}}
+	out := gpx.NewWriter(w, "carve")
+	out.Version, out.NoNMEA, out.Debug = *gpxVersion, *noNMEA, *debug
+	out.Offsets = true
+	desc := fmt.Sprintf("Carved from %s: %d tracks", imagePath, len(tracks))
+	if err = out.Header(desc); err != nil {
+		return err
+	}
+	for _, t := range tracks {
+		name := t[0].Time.Format("2006-01-02T15:04:05Z")
+		if err = out.Track(name, t); err != nil {
+			return err
+		}
+	}
+	return out.Footer()
+}
+//}}}
+//{{{  scan(r) -- every decodable fix, and how many blocks were seen
+func scan(r io.Reader) (fixes []nb.Fix, blocks int, err error) {
+	err = nb.ScanLogs(r, func(off int64, g *nb.GPSLog) error {
+		blocks++
+		fix, ok := nb.Decode(g)
+		if !ok {
+			if *debug {
+				log.Printf("%#x: GPS block with no time\n", off)
+			}
+			return nil
+		}
+		fix.Offset = off
+		fixes = append(fixes, fix)
+		return nil
+	})
+	if *rubbish {
+		fixes = nb.Clean(fixes)
+	}
+	return fixes, blocks, err
+}
+//}}}
+//{{{  group(fixes) -- tracks by time continuity
+// Files are scattered over a card in no particular order, so the fixes are
+// put in time order, those at the same time staying in image order. A
+// fix at the same time and place as one already in the track is another
+// copy of the same block, left behind when a file was rewritten, and is
+// dropped.
+// A track ends where GPS time jumps by more than -gap.
+func group(fixes []nb.Fix) [][]nb.Fix {
+	sort.SliceStable(fixes, func(i, j int) bool {
+		return fixes[i].Time.Before(fixes[j].Time)
+	})
+	var tracks [][]nb.Fix
+	var cur []nb.Fix
+	//{{{  end -- finish the current track
+	end := func() {
+		if len(cur) >= *minPoints {
+			nb.Spread(cur)
+			tracks = append(tracks, cur)
+		}
+		cur = nil
+	}
+	//}}}
+	for _, fix := range fixes {
+		if n := len(cur); n > 0 {
+			switch {
+			case copied(cur, &fix):
+				continue
+			case fix.Time.Sub(cur[n-1].Time) > *gap:
+				end()
+			}
+		}
+		cur = append(cur, fix)
+	}
+	end()
+	return tracks
+}
+//}}}
+//{{{  copied(track,fix) -- is fix already at the end of track?
+// Only the fixes at the same time need looking at: track is in time order.
+func copied(track []nb.Fix, fix *nb.Fix) bool {
+	for k := len(track) - 1; k >= 0 && track[k].Time.Equal(fix.Time); k-- {
+		if track[k].Lat == fix.Lat && track[k].Lon == fix.Lon {
+			return true
+		}
+	}
+	return false
+}
+//}}}
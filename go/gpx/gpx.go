@@ -0,0 +1,416 @@
+//{{{  license
+// Copyright 2016 KB Sriram
+// Copyright 2018,2019 A E Lawrence
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//}}}
+
+// Package gpx writes decoded fixes as gpx 1.0 or 1.1, for the commands
+// which extract or recover tracks.
+package gpx
+
+//{{{  imports
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"github.com/clarified/mov2gps/go/nb"
+	"github.com/clarified/mov2gps/go/nmea"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+)
+//}}}
+
+// Namespace of the extension elements, declared with prefix m2g.
+const Namespace = "https://github.com/clarified/mov2gps/gpx/v1"
+
+//{{{  type Writer -- gpx output and the choices about what goes in it
+// Version is 0 or 1 for gpx 1.0 or 1.1. NoNMEA leaves out what only the
+// NMEA sentences give (elevation, dilution of precision and so on).
+// FlagVoid adds a <cmt> to points the receiver marked void. Offsets records
+// the byte offset of each point's GPS block as <m2g:offset>. Synthetic
+// marks points made up to fill gaps (Fix.Synthetic) with <m2g:synthetic>,
+// Repaired outliers moved into line (Fix.Repaired) with <m2g:repaired>, and
+// Interpolated points between fixes (Fix.Interpolated) with
+// <m2g:interpolated>.
+type Writer struct {
+	w		*bufio.Writer
+	Version		int
+	Creator		string
+	NoNMEA		bool
+	FlagVoid	bool
+	Offsets		bool
+	Synthetic	bool
+	Repaired	bool
+	Interpolated	bool
+	Debug		bool
+}
+//}}}
+//{{{  NewWriter(w,creator) -- gpx 1.1 by default
+// Set any fields before calling Header. Footer flushes.
+func NewWriter(w io.Writer, creator string) *Writer {
+	return &Writer{w: bufio.NewWriter(w), Version: 1, Creator: creator}
+}
+//}}}
+
+//{{{  escape(s) -- s as XML character data or attribute value
+// Paths and descriptions may hold & or <.
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+//}}}
+
+//{{{  Method Header(desc) error
+// desc, if not empty, is written as a description of the whole file:
+// in <metadata> for 1.1, directly for 1.0.
+func (gw *Writer) Header(desc string) error {
+	w := gw.w
+
+	gver := strconv.Itoa(gw.Version)
+
+	_, err := w.WriteString(`<?xml version="1.0" encoding="UTF-8" ?>
+<gpx
+ xmlns="http://www.topografix.com/GPX/1/`)
+ 
+	_, err = w.WriteString(gver)
+	_, err = w.WriteString( `"
+ xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"
+ xsi:schemaLocation="http://www.topografix.com/GPX/1/`)
+	_, err = w.WriteString(gver)
+	_, err = w.WriteString( ` http://www.topografix.com/GPX/1/`)
+	_, err = w.WriteString(gver)
+	_, err = w.WriteString( `/gpx.xsd"
+`)
+	if gw.Version == 1 {
+		_, err = w.WriteString(
+		  ` xmlns:gpxtpx="http://www.garmin.com/xmlschemas/TrackPointExtension/v2"
+`)
+	}
+	if gw.Offsets || gw.Synthetic || gw.Repaired || gw.Interpolated {
+		_, err = w.WriteString(` xmlns:m2g="` + Namespace + `"
+`)
+	}
+	_, err = w.WriteString( ` version="1.`)
+	_, err = w.WriteString(gver)
+	_, err = w.WriteString(`"
+ creator="` + escape(gw.Creator) + `">`)
+	if desc != "" {
+		if gw.Version == 1 {
+			_, err = w.WriteString(`
+  <metadata>
+    <desc>` + escape(desc) + `</desc>
+  </metadata>`)
+		} else {
+			_, err = w.WriteString(`
+  <desc>` + escape(desc) + `</desc>`)
+		}
+	}
+	return err
+}
+//}}}
+//{{{  Method Track(name,segments) error
+// One <trk>, with a <trkseg> for each segment. name may be "".
+func (gw *Writer) Track(name string, segments ...[]nb.Fix) error {
+	w := gw.w
+	w.WriteString(`
+  <trk>`)
+	if name != "" {
+		w.WriteString(`
+    <name>` + escape(name) + `</name>`)
+	}
+	for _, seg := range segments {
+		w.WriteString(`
+    <trkseg>`)
+		for i := range seg {
+			if err := gw.Point(&seg[i]); err != nil {
+				return err
+			}
+		}
+		w.WriteString(`
+    </trkseg>`)
+	}
+	_, err := w.WriteString(`
+  </trk>`)
+	return err
+}
+//}}}
+//{{{  Method Footer() error -- close and flush
+func (gw *Writer) Footer() error {
+	if _, err := gw.w.WriteString(`
+</gpx>
+`); err != nil {
+		return err
+	}
+	return gw.w.Flush()
+}
+//}}}
+//{{{  timeFormat -- ISO 8601, fraction only when there is one
+// Fractional seconds come from RMC sentences of receivers faster
+// than 1 Hz.
+const timeFormat = "2006-01-02T15:04:05.999Z07:00"
+//}}}
+//{{{  Method Point(fix) error
+// https://en.wikipedia.org/wiki/GPS_Exchange_Format
+// & the gpx xsd schemas.
+
+func (gw *Writer) Point(fix *nb.Fix) error {
+	w := gw.w
+
+	//{{{  GGA only if a good sentence and wanted
+	// RMC has speed, but we need conversion from knots, so no advantage
+	// over direct value. The decoder uses RMC when the binary fields
+	// are missing, and debug shows both in case some dash cam
+	// only includes RMC. Likewise GGA is parsed even when noNMEA so that
+	// debug can probe unknown firmware/dashcams.
+	// RMC and GGA entries can be truncated or corrupt, but the decoder
+	// only keeps sentences with a good checksum, and those can be trusted
+	// as far as the fields they mark Valid.
+	// Any talker is accepted: $GNGGA and the like from receivers using
+	// GLONASS or Galileo as well as GPS.
+	// <pdop> and <vdop> are not written. They need a GSA sentence, and no
+	// camera seen so far writes one in its GPS block, so where one would
+	// be is unknown: a guessed place would give values from whatever
+	// bytes happen to be there.
+
+	ggaS := fix.GGA
+	if gw.NoNMEA {
+		ggaS = nil
+	}
+
+	//}}}
+	//{{{  Lat,Lon attributes
+	w.WriteString(fmt.Sprintf(`
+      <trkpt lat="%.6f" lon="%.6f">`, fix.Lat, fix.Lon))
+	//}}}
+	//{{{  SpeedCourse(first) -- anon so closure.
+	var speedCourse func(bool) = func(first bool) {
+
+		//{{{  Do nothing if first does not match gpxVersion
+		// When first is true, only active for gpx1.0.
+		//  If first is false, only active for gpx1.1
+		// If anyone has set -g negative, they deserve what they get!
+		if (!first && gw.Version == 0 ) || (first && gw.Version == 1 ) {
+		      return
+		}
+		//}}}
+
+		var prefix,speed,course,courseVal,ctag string
+		var testCourse bool
+
+		//{{{  open extension if 1.1 & set prefix while we are here
+		if gw.Version == 1 {
+		      w.WriteString(`
+	<extensions>
+	  <gpxtpx:TrackPointExtension>`)
+
+		prefix = "gpxtpx:"
+		}
+		//}}}
+
+		//{{{  build speed
+		// speed already converted from knots to m/s
+
+		//{{{  COMMENT Simple speed, but multiple string copies
+		//     speed = `
+		//<` + prefix + `speed>` +
+		//     fmt.Sprintf("%.6f", fix.Speed) +  `</` +
+		//     prefix + `speed>`
+		//}}}
+		//{{{  Builder:  marginally more efficient way to build speed
+		stag := prefix + `speed`
+		var sb strings.Builder
+
+		sb.WriteString(`
+	   <`)
+		sb.WriteString(stag)
+		sb.WriteString(`>`)
+		sb.WriteString( fmt.Sprintf("%.6f", fix.Speed))
+		sb.WriteString(`</`)
+		sb.WriteString(stag)
+		sb.WriteString(`>`)
+		speed = sb.String()
+
+		//}}}
+		//}}}
+		//{{{  course & testCourse
+		// At low speeds, 0 values for course appear to mean unknown, so
+		// skip such points
+		// It turns out that RMC can be truncated, so rather than
+		// do checks for validity, just collect from main log
+		// 2 knots, as the original
+		testCourse = fix.Speed > 2*1852.0/3600.0 || fix.Course > 0.00001
+		if testCourse {
+			courseVal = fmt.Sprintf("%.6f",fix.Course)
+		}
+
+		// Probably not worth using a Builder for course
+		ctag   = prefix + `course>`
+		course = `
+	   <` + ctag + courseVal + `</` + ctag
+
+		//}}}
+
+	      if gw.Version == 1 {
+		      w.WriteString(speed)
+		      if testCourse {
+			      w.WriteString(course)
+		      }
+	      } else {
+		      if testCourse {
+			      w.WriteString(course)
+		      } 
+		      w.WriteString(speed)
+	      }
+
+	      //{{{  Close extension for 1.1
+	      if gw.Version == 1 {
+		      w.WriteString(`
+	  </gpxtpx:TrackPointExtension>`)
+		      gw.extensions(fix)
+		      w.WriteString(`
+       </extensions>`)
+	      }
+	      //}}}
+	}
+      //}}}
+
+	//{{{  debug for RMC,GGA
+	if gw.Debug {
+		if fix.RMC != nil {
+			log.Printf("RMC present (%s): fields = %q\n",
+				fix.Constellation, fix.RMC.Fields)
+		}
+		if fix.FromRMC {
+			log.Printf("binary fields missing or inconsistent: used RMC\n")
+		}
+		if ggaS != nil {
+			log.Printf("GGA present (%s): fields = %q\n",
+				fix.Constellation, ggaS.Fields)
+		}
+	}
+	//}}}
+
+       //{{{  <ele>
+       // Height in metres (no idea what other units can occur in gHUnit)
+       // gHeight can sometimes be empty which leads to a strictly invalid
+       // gpx file. 
+       if ggaS != nil {
+		if ggaS.Valid(nmea.GGAAltitude) && ggaS.AltUnit == 'M' {
+			w.WriteString(fmt.Sprintf(`
+	<ele>%s</ele>`, ggaS.Field(nmea.GGAAltitude)))
+		}
+	}
+//}}}
+       //{{{  <time>
+       //{{{ Time should (must) be in UTC, formatted as ISO8601 
+       // sggps did something strange here.
+       // It assumed that the time read from the MOV was local
+       // rather than UTC. Maybe some dashcams do that: after all
+       // the displayed value in the video is local.
+       // But Nextbase, at least, seem to use UTC in the gps record.
+
+       // The gpx specfication requires that the time is UTC
+       // and formatted according to ISO 8601. sggps conformed to
+       // ISO 8601, but violated gpx by using local time in the gpx.
+       // The original sggps code looked like:
+       //	_, offset := time.Now().Zone()
+       //	var neg byte
+       //	if offset < 0 {
+       //		neg = '-'
+       //		offset = -offset
+       //	} else {
+       //		neg = '+'
+       //	}
+       //
+       //	hOffset := offset / 3600
+       //	sOffset := offset % 3600
+
+       //}}}
+       w.WriteString(fmt.Sprintf(`
+        <time>%s</time>`, fix.Time.Format(timeFormat)))
+//}}}
+	speedCourse(true)
+	//{{{  <geoidheight>
+	if ggaS != nil && ggaS.Valid(nmea.GGAGeoid) && ggaS.GeoidUnit == 'M' {
+		w.WriteString(fmt.Sprintf(`
+	<geoidheight>%s</geoidheight>`, ggaS.Field(nmea.GGAGeoid)))
+	}
+	//}}}
+	//{{{  <cmt> -- only for void points with FlagVoid
+	if gw.FlagVoid && fix.Status == 'V' {
+		w.WriteString(`
+	<cmt>receiver status V: void</cmt>`)
+	}
+	//}}}
+	//{{{  <fix>
+	// The receiver status is binary, so NoNMEA doesn't stop a void point
+	// being marked none.
+	q := nb.Fix{Status: fix.Status, GGA: ggaS}
+	if ft := q.FixType(); ft != "" {
+		w.WriteString(fmt.Sprintf(`
+	<fix>%s</fix>`, ft))
+	}
+	//}}}
+	//{{{  <sat> -- GGA count
+	// Both gpx versions have <sat>, in the same place.
+	if ggaS != nil && ggaS.Valid(nmea.GGASats) {
+		w.WriteString(fmt.Sprintf(`
+	<sat>%d</sat>`, ggaS.Sats))
+	}
+	//}}}
+	//{{{  <hdop> -- GGA
+	if ggaS != nil && ggaS.Valid(nmea.GGAHDOP) {
+		w.WriteString(fmt.Sprintf(`
+	<hdop>%s</hdop>`, ggaS.Field(nmea.GGAHDOP)))
+	}
+	//}}}
+	speedCourse(false)
+	//{{{  1.0 allows other namespaces at the end of the point
+	if gw.Version == 0 {
+		gw.extensions(fix)
+	}
+	//}}}
+
+      //{{{  /trkpt
+	w.WriteString(`
+     </trkpt>`)
+      //}}}
+
+	return nil
+}
+//}}}
+//{{{  Method extensions(fix) -- m2g elements, if wanted
+// Where the point was found, and whether it was made up or moved.
+func (gw *Writer) extensions(fix *nb.Fix) {
+	if gw.Offsets && fix.Offset >= 0 {
+		gw.w.WriteString(fmt.Sprintf(`
+	<m2g:offset>%d</m2g:offset>`, fix.Offset))
+	}
+	if gw.Synthetic && fix.Synthetic {
+		gw.w.WriteString(`
+	<m2g:synthetic>true</m2g:synthetic>`)
+	}
+	if gw.Repaired && fix.Repaired {
+		gw.w.WriteString(`
+	<m2g:repaired>true</m2g:repaired>`)
+	}
+	if gw.Interpolated && fix.Interpolated && !fix.Synthetic {
+		gw.w.WriteString(`
+	<m2g:interpolated>true</m2g:interpolated>`)
+	}
+}
+//}}}
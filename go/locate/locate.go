@@ -0,0 +1,157 @@
+//{{{  license
+// Copyright 2018,2019 A E Lawrence
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//}}}
+
+// locate answers two questions about dashcam MOV files:
+// where was the camera at some time into a clip (-t), and which clips,
+// at what times, passed near a place (-lat, -lon, -r).
+package main
+
+//{{{  imports
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/clarified/mov2gps/go/nb"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+//}}}
+//{{{  flags
+var (
+	at	= flag.String("t", "",
+		"Media time into the clip: [[h:]m:]s[.fff]")
+	lat	= flag.Float64("lat", math.NaN(), "Latitude of place, decimal degrees")
+	lon	= flag.Float64("lon", math.NaN(), "Longitude of place, decimal degrees")
+	radius	= flag.Float64("r", 50, "Radius around place, metres")
+	debug	= flag.Bool("debug", false, "tracing to stderr")
+)
+//}}}
+//{{{  usage
+func usage() {
+	fmt.Fprintln(os.Stderr,
+		"usage: locate -t time file.MOV\n       locate -lat lat -lon lon [-r metres] file ...")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+//}}}
+
+//{{{  main
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	byPlace := !math.IsNaN(*lat) && !math.IsNaN(*lon)
+	if flag.NArg() == 0 || (*at == "") == !byPlace {
+		usage()
+	}
+	nb.SetDebug(*debug)
+
+	if !byPlace {
+		//{{{  One clip, one time
+		if flag.NArg() != 1 {
+			usage()
+		}
+		t, err := parseMediaTime(*at)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err = locateTime(flag.Arg(0), t); err != nil {
+			log.Fatal(err)
+		}
+		return
+		//}}}
+	}
+	for i := 0; i < flag.NArg(); i++ {
+		if err := locatePlace(flag.Arg(i)); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+//}}}
+
+//{{{  parseMediaTime(s) -- [[h:]m:]s[.fff]
+func parseMediaTime(s string) (time.Duration, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return 0, errors.New(fmt.Sprintf("%s: not a media time", s))
+	}
+	var secs float64
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil || v < 0 ||
+			(i < len(parts)-1 && v != math.Trunc(v)) {
+			return 0, errors.New(fmt.Sprintf("%s: not a media time", s))
+		}
+		secs = secs*60 + v
+	}
+	return time.Duration(secs * float64(time.Second)), nil
+}
+//}}}
+//{{{  formatMedia(d) -- h:mm:ss.s
+func formatMedia(d time.Duration) string {
+	// Rounded to the tenth first, so that 1:59.97 is 2:00.0, not 1:60.0
+	tenths := d.Round(100 * time.Millisecond) / (100 * time.Millisecond)
+	h := tenths / 36000
+	m := tenths / 600 % 60
+	return fmt.Sprintf("%d:%02d:%04.1f", h, m, float64(tenths%600)/10)
+}
+//}}}
+//{{{  fixesOf(movPath) -- open and decode
+func fixesOf(movPath string) ([]nb.Fix, error) {
+	movFile, err := os.Open(movPath)
+	if err != nil {
+		return nil, err
+	}
+	defer movFile.Close()
+	fixes, _, err := nb.NewInfo(movFile).Fixes()
+	return nb.Clean(fixes), err
+}
+//}}}
+//{{{  locateTime(movPath,t) -- print the fix at media time t
+func locateTime(movPath string, t time.Duration) error {
+	fixes, err := fixesOf(movPath)
+	if err != nil {
+		return err
+	}
+	fix, err := nb.FixAt(fixes, t)
+	if err != nil {
+		return errors.New(fmt.Sprintf("%s %s: %v", movPath, formatMedia(t), err))
+	}
+	fmt.Printf("%s %s %.6f %.6f %s %.1fm/s %.0f°\n",
+		movPath, formatMedia(t), fix.Lat, fix.Lon,
+		fix.Time.Format("2006-01-02T15:04:05.0Z"), fix.Speed, fix.Course)
+	return nil
+}
+//}}}
+//{{{  locatePlace(movPath) -- print each pass near the place
+// Silent for clips which never come near.
+func locatePlace(movPath string) error {
+	fixes, err := fixesOf(movPath)
+	if err != nil {
+		return err
+	}
+	for _, p := range nb.Passes(fixes, *lat, *lon, *radius) {
+		fmt.Printf("%s %s-%s closest %.0fm at %s %s\n",
+			movPath, formatMedia(p.Enter), formatMedia(p.Leave),
+			p.Distance, formatMedia(p.Closest.Media),
+			p.Closest.Time.Format("2006-01-02T15:04:05.0Z"))
+	}
+	return nil
+}
+//}}}
@@ -0,0 +1,211 @@
+//{{{  license
+// Copyright 2016 KB Sriram
+// Copyright 2018 AE Lawrence
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//}}}
+
+package mov
+
+//{{{  imports
+import (
+	"errors"
+	"io"
+	"log"
+	"strings"
+)
+//}}}
+
+var ErrBadSelector = errors.New("Malformed atom selector")
+
+//{{{  Selectors -- overview
+// A selector is a path of atom types separated by '/', starting at the
+// top level of the file, for example
+//	moov/trak[hdlr=soun]/mdia/minf/stbl/stco
+// Each step names an atom type, or '*' for any type. A step may carry a
+// single predicate in square brackets, [key=value], which only admits
+// atoms containing (at any depth) an atom of type key whose value matches.
+// The value of an atom is given by the entry for key in predicateValues.
+// Unlike VisitAtoms, Find will descend into any atom named in the selector,
+// whether or not it is in the default container list.
+//}}}
+
+//{{{  type step -- one parsed element of a selector
+type step struct {
+	typ	string	// atom type or "*"
+	key	string	// predicate atom type, "" if no predicate
+	value	string	// predicate value
+}
+//}}}
+//{{{  predicateValues -- how to get a comparable value from an atom
+// Only hdlr so far: its value is the handler (component subtype),
+// such as "vide" or "soun". It follows version/flags and the
+// component type. Quicktime also puts a data handler (dhlr) hdlr in
+// minf: that has no useful value, so it gives "" and is passed over.
+var predicateValues = map[string]func(*io.SectionReader) (string, error){
+	"hdlr": func(sr *io.SectionReader) (string, error) {
+		ts := make([]byte, 8)
+		if _, err := sr.ReadAt(ts, 4); err != nil {
+			return "", err
+		}
+		if string(ts[:4]) == "dhlr" {
+			return "", nil
+		}
+		return string(ts[4:]), nil
+	},
+}
+//}}}
+
+//{{{  parseSelector(sel) -- split into steps
+func parseSelector(sel string) ([]step, error) {
+	if sel == "" {
+		return nil, ErrBadSelector
+	}
+	parts := strings.Split(sel, "/")
+	steps := make([]step, len(parts))
+	for i, p := range parts {
+		//{{{  Plain type, no predicate
+		open := strings.IndexByte(p, '[')
+		if open < 0 {
+			if p == "" {
+				return nil, ErrBadSelector
+			}
+			steps[i].typ = p
+			continue
+		}
+		//}}}
+		//{{{  Type with [key=value]
+		if open == 0 || !strings.HasSuffix(p, "]") {
+			return nil, ErrBadSelector
+		}
+		steps[i].typ = p[:open]
+		kv := strings.SplitN(p[open+1:len(p)-1], "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, ErrBadSelector
+		}
+		if _, ok := predicateValues[kv[0]]; !ok {
+			return nil, ErrBadSelector
+		}
+		steps[i].key, steps[i].value = kv[0], kv[1]
+		//}}}
+	}
+	return steps, nil
+}
+//}}}
+//{{{  contains(sr,key) -- value of first key atom anywhere in sr
+// Descends through the default containers looking for an atom of type key,
+// and returns its value. ok is false when there is no such atom. A key
+// atom too short to give a value is passed over, as if it weren't there.
+// As for VisitAtoms and findIn, a partial header ends the list of atoms.
+func contains(sr *io.SectionReader, key string,
+			containers map[string]bool) (value string, ok bool, err error) {
+	sr = io.NewSectionReader(sr, 0, sr.Size())	// leave caller's offset
+	for {
+		ctype, csr, err := nextAtom(sr)
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return "", false, nil
+			}
+			return "", false, err
+		}
+		if ctype == key {
+			value, err := predicateValues[key](csr)
+			switch {
+			case err != nil:
+				if debug {
+					log.Printf("%s unreadable, no match: %v\n", key, err)
+				}
+			case value != "":
+				return value, true, nil
+			}
+		}
+		if containers[ctype] {
+			if value, ok, err = contains(csr, key, containers); ok || err != nil {
+				return value, ok, err
+			}
+		}
+	}
+}
+//}}}
+//{{{  findIn(sr,steps,...) -- collect the atoms in sr matching steps
+// A partial header at the end of the list ends it, as for VisitAtoms: the
+// atoms before it are still found.
+func findIn(sr *io.SectionReader, steps []step, opts *WalkOptions,
+		found []*io.SectionReader) ([]*io.SectionReader, error) {
+	s := steps[0]
+	for {
+		ctype, csr, err := nextAtom(sr)
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return found, nil
+			}
+			return found, err
+		}
+		if s.typ != "*" && s.typ != ctype {
+			continue
+		}
+		//{{{  Check predicate if any
+		if s.key != "" {
+			value, ok, err := contains(csr, s.key, opts.Containers)
+			if err != nil {
+				return found, err
+			}
+			if !ok || value != s.value {
+				continue
+			}
+		}
+		//}}}
+		if len(steps) == 1 {
+			found = append(found, csr)
+			continue
+		}
+		if opts.FullBoxes[ctype] {
+			if err = skipFullBox(csr); err != nil {
+				return found, err
+			}
+		}
+		if found, err = findIn(csr, steps[1:], opts, found); err != nil {
+			return found, err
+		}
+	}
+}
+//}}}
+//{{{  Find(rs,selector) -- section readers for the atoms matching selector
+// Find returns a SectionReader for the body of each atom in rs matching
+// selector, in file order. No match is not an error: the result is empty.
+// Like VisitAtoms, it has the side effect of Seeking to the end of rs.
+func Find(rs ReadAtSeeker, selector string) ([]*io.SectionReader, error) {
+	end, err := seekEnd(rs)
+	if err != nil {
+		return nil, err
+	}
+	return FindIn(io.NewSectionReader(rs, 0, end), selector)
+}
+//}}}
+//{{{  FindIn(sr,selector) -- as Find, but relative to an atom body
+// FindIn is Find with the selector starting at the atoms contained in sr,
+// typically the body of an atom returned by an earlier Find.
+// The offset of sr is not disturbed.
+func FindIn(sr *io.SectionReader, selector string) ([]*io.SectionReader, error) {
+	steps, err := parseSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	found, err := findIn(io.NewSectionReader(sr, 0, sr.Size()), steps,
+				DefaultOptions(), nil)
+	if debug {
+		log.Printf("Find %q: %d matches\n", selector, len(found))
+	}
+	return found, err
+}
+//}}}
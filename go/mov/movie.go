@@ -0,0 +1,99 @@
+//{{{  license
+// Copyright 2016 KB Sriram
+// Copyright 2018 AE Lawrence
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//}}}
+
+package mov
+
+//{{{  imports
+import (
+	"encoding/binary"
+	"errors"
+	"log"
+	"time"
+)
+//}}}
+
+var ErrNoMovie = errors.New("No moov/mvhd atom")
+
+//{{{  MacTime(secs) -- Quicktime time stamps
+// Quicktime counts seconds from the start of 1904. The spec says UTC, but
+// cameras commonly write their own local clock, so the result is only
+// "UTC" in name: compare with GPS time before trusting it.
+// Zero means not set, and gives the zero time.
+var macEpoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func MacTime(secs uint64) time.Time {
+	if secs == 0 {
+		return time.Time{}
+	}
+	return macEpoch.Add(time.Duration(secs) * time.Second)
+}
+//}}}
+//{{{  type Movie -- summary of mvhd
+// Duration is in units of Timescale per second.
+type Movie struct {
+	Created		time.Time
+	Modified	time.Time
+	Timescale	uint32
+	Duration	uint64
+}
+//}}}
+//{{{  Method Seconds -- duration of the movie
+func (m *Movie) Seconds() float64 {
+	if m.Timescale == 0 {
+		return 0
+	}
+	return float64(m.Duration) / float64(m.Timescale)
+}
+//}}}
+//{{{  ReadMovie(rs) -- decode moov/mvhd
+// Like VisitAtoms, it has the side effect of Seeking to the end of rs.
+func ReadMovie(rs ReadAtSeeker) (*Movie, error) {
+	found, err := Find(rs, "moov/mvhd")
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, ErrNoMovie
+	}
+	sr := found[0]
+	var m Movie
+	var vf uint32
+	if err = binary.Read(sr, binary.BigEndian, &vf); err != nil {
+		return nil, err
+	}
+	if m.Created, m.Modified, err = readTimes(sr, vf>>24); err != nil {
+		return nil, err
+	}
+	if err = binary.Read(sr, binary.BigEndian, &m.Timescale); err != nil {
+		return nil, err
+	}
+	//{{{  Duration: 32 bits in version 0, 64 in version 1
+	if vf>>24 == 0 {
+		var d uint32
+		err = binary.Read(sr, binary.BigEndian, &d)
+		m.Duration = uint64(d)
+	} else {
+		err = binary.Read(sr, binary.BigEndian, &m.Duration)
+	}
+	//}}}
+	if debug {
+		log.Printf("mvhd created %v, timescale %d duration %d\n",
+			m.Created, m.Timescale, m.Duration)
+	}
+	return &m, err
+}
+//}}}
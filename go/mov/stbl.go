@@ -0,0 +1,472 @@
+//{{{  license
+// Copyright 2016 KB Sriram
+// Copyright 2018 AE Lawrence
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//}}}
+
+package mov
+
+//{{{  imports
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+)
+//}}}
+
+var ErrBadTable = errors.New("Corrupt or inconsistent sample table")
+
+//{{{  Sample tables -- overview
+// The stbl atom describes where every sample (video frame, block of audio)
+// lies and when it is played:
+//	stco/co64	file offset of each chunk (a run of adjacent samples)
+//	stsc		samples per chunk, as runs of chunks
+//	stsz		size of each sample
+//	stts		decode duration of each sample, run length encoded
+//	ctts		presentation minus decode time, run length encoded
+//	stss		the sync samples (keyframes), numbered from 1
+// Each table starts with version/flags and an entry count. The counts are
+// checked against the atom size before anything is allocated, so that a
+// corrupt count can't demand gigabytes.
+//}}}
+
+//{{{  type StscEntry, SttsEntry, CttsEntry
+// StscEntry says that chunks from FirstChunk (numbered from 1) up to the
+// next entry each hold SamplesPerChunk samples.
+type StscEntry struct {
+	FirstChunk	uint32
+	SamplesPerChunk	uint32
+	DescriptionID	uint32
+}
+
+// SttsEntry says that the next Count samples each last Delta.
+type SttsEntry struct {
+	Count	uint32
+	Delta	uint32
+}
+
+// CttsEntry says that the next Count samples are presented Offset after
+// they are decoded. Offset may be negative in version 1 tables, and
+// Quicktime treats it as signed anyway.
+type CttsEntry struct {
+	Count	uint32
+	Offset	int32
+}
+//}}}
+//{{{  type Sample -- everything known about one sample
+// Times are in units of the track Timescale. Index counts from 0.
+type Sample struct {
+	Index			int
+	Chunk			int		// from 0
+	Offset			int64		// in the file
+	Size			uint32
+	DecodeTime		uint64
+	PresentationTime	int64
+	Keyframe		bool
+}
+//}}}
+
+//{{{  tableHeader(sr,entrySize) -- skip version/flags, checked count
+func tableHeader(sr *io.SectionReader, entrySize int64) (uint32, error) {
+	var h struct {
+		_	uint32	// version/flags
+		N	uint32
+	}
+	if _, err := sr.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if err := binary.Read(sr, binary.BigEndian, &h); err != nil {
+		return 0, err
+	}
+	if int64(h.N)*entrySize > sr.Size()-8 {
+		return 0, ErrBadTable
+	}
+	return h.N, nil
+}
+//}}}
+//{{{  readEntries(sr,result) -- the table following the header
+// result is a slice already made to the checked count.
+func readEntries(sr *io.SectionReader, result interface{}) error {
+	return binary.Read(sr, binary.BigEndian, result)
+}
+//}}}
+
+//{{{  ReadStco(sr) -- 32 bit chunk offsets
+func ReadStco(sr *io.SectionReader) ([]uint64, error) {
+	n, err := tableHeader(sr, 4)
+	if err != nil {
+		return nil, err
+	}
+	offsets32 := make([]uint32, n)
+	if err = readEntries(sr, offsets32); err != nil {
+		return nil, err
+	}
+	offsets := make([]uint64, n)
+	for i, o := range offsets32 {
+		offsets[i] = uint64(o)
+	}
+	return offsets, nil
+}
+//}}}
+//{{{  ReadCo64(sr) -- 64 bit chunk offsets
+func ReadCo64(sr *io.SectionReader) ([]uint64, error) {
+	n, err := tableHeader(sr, 8)
+	if err != nil {
+		return nil, err
+	}
+	offsets := make([]uint64, n)
+	return offsets, readEntries(sr, offsets)
+}
+//}}}
+//{{{  ReadStsc(sr) -- sample to chunk runs
+func ReadStsc(sr *io.SectionReader) ([]StscEntry, error) {
+	n, err := tableHeader(sr, 12)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]StscEntry, n)
+	return entries, readEntries(sr, entries)
+}
+//}}}
+//{{{  readStsz(sr) -- uniform size, count, and the table if not uniform
+// A non-zero uniform size means there is no table: every sample has that
+// size, and sizes is nil.
+func readStsz(sr *io.SectionReader) (uniform, n uint32, sizes []uint32, err error) {
+	var h struct {
+		_	uint32	// version/flags
+		Uniform	uint32
+		N	uint32
+	}
+	if _, err = sr.Seek(0, io.SeekStart); err != nil {
+		return 0, 0, nil, err
+	}
+	if err = binary.Read(sr, binary.BigEndian, &h); err != nil {
+		return 0, 0, nil, err
+	}
+	//{{{  Uniform size: no table, but still check the count is sane
+	// The count is not bounded by the atom size here, so limit it to
+	// 2^28 samples, far more than any camera clip.
+	if h.Uniform != 0 {
+		if h.N > 1<<28 {
+			return 0, 0, nil, ErrBadTable
+		}
+		return h.Uniform, h.N, nil, nil
+	}
+	//}}}
+	if int64(h.N)*4 > sr.Size()-12 {
+		return 0, 0, nil, ErrBadTable
+	}
+	sizes = make([]uint32, h.N)
+	return 0, h.N, sizes, readEntries(sr, sizes)
+}
+//}}}
+//{{{  ReadStsz(sr) -- size of every sample
+// The result is expanded even when the size is uniform.
+func ReadStsz(sr *io.SectionReader) ([]uint32, error) {
+	uniform, n, sizes, err := readStsz(sr)
+	if err != nil || sizes != nil {
+		return sizes, err
+	}
+	sizes = make([]uint32, n)
+	for i := range sizes {
+		sizes[i] = uniform
+	}
+	return sizes, nil
+}
+//}}}
+//{{{  ReadStts(sr) -- decode time runs
+func ReadStts(sr *io.SectionReader) ([]SttsEntry, error) {
+	n, err := tableHeader(sr, 8)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]SttsEntry, n)
+	return entries, readEntries(sr, entries)
+}
+//}}}
+//{{{  ReadCtts(sr) -- composition offset runs
+func ReadCtts(sr *io.SectionReader) ([]CttsEntry, error) {
+	n, err := tableHeader(sr, 8)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]CttsEntry, n)
+	return entries, readEntries(sr, entries)
+}
+//}}}
+//{{{  ReadStss(sr) -- sync sample numbers, from 1
+func ReadStss(sr *io.SectionReader) ([]uint32, error) {
+	n, err := tableHeader(sr, 4)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]uint32, n)
+	return entries, readEntries(sr, entries)
+}
+//}}}
+
+//{{{  Method ChunkOffsets -- from stco, or co64 for large files
+func (t *Track) ChunkOffsets() ([]uint64, error) {
+	if t.Stbl == nil {
+		return nil, nil
+	}
+	sr, err := firstIn(t.Stbl, "stco")
+	if err != nil {
+		return nil, err
+	}
+	if sr != nil {
+		return ReadStco(sr)
+	}
+	if sr, err = firstIn(t.Stbl, "co64"); err != nil || sr == nil {
+		return nil, err
+	}
+	return ReadCo64(sr)
+}
+//}}}
+//{{{  Method Samples -- resolve every sample in the track
+// Samples combines the sample tables of the track into one Sample per
+// sample, in decode order. Missing stss means every sample is a keyframe;
+// missing ctts means presentation time equals decode time. The other
+// tables must be present and agree with each other.
+func (t *Track) Samples() ([]Sample, error) {
+	if t.Stbl == nil {
+		return nil, nil
+	}
+	//{{{  Read all the tables
+	chunks, err := t.ChunkOffsets()
+	if err != nil {
+		return nil, err
+	}
+	var stsc []StscEntry
+	var sizes []uint32
+	var stts []SttsEntry
+	var ctts []CttsEntry
+	var stss []uint32
+	hasStss := false
+	for _, tb := range []struct {
+		typ	string
+		read	func(*io.SectionReader) error
+	}{
+		{"stsc", func(sr *io.SectionReader) (err error) {
+			stsc, err = ReadStsc(sr); return }},
+		{"stsz", func(sr *io.SectionReader) (err error) {
+			sizes, err = ReadStsz(sr); return }},
+		{"stts", func(sr *io.SectionReader) (err error) {
+			stts, err = ReadStts(sr); return }},
+		{"ctts", func(sr *io.SectionReader) (err error) {
+			ctts, err = ReadCtts(sr); return }},
+		{"stss", func(sr *io.SectionReader) (err error) {
+			hasStss = true
+			stss, err = ReadStss(sr); return }},
+	} {
+		sr, err := firstIn(t.Stbl, tb.typ)
+		if err != nil {
+			return nil, err
+		}
+		if sr == nil {
+			continue
+		}
+		if err = tb.read(sr); err != nil {
+			return nil, err
+		}
+	}
+	if len(stsc) == 0 || stsc[0].FirstChunk != 1 {
+		return nil, ErrBadTable
+	}
+	//}}}
+	samples := make([]Sample, len(sizes))
+	//{{{  Offsets and chunks from stco/stsc/stsz
+	s := 0
+	for c := range chunks {
+		//{{{  Find the stsc run holding chunk c (numbered from 1 in stsc)
+		e := 0
+		for e+1 < len(stsc) && uint32(c+1) >= stsc[e+1].FirstChunk {
+			e++
+		}
+		//}}}
+		off := int64(chunks[c])
+		for k := uint32(0); k < stsc[e].SamplesPerChunk; k++ {
+			if s >= len(samples) {
+				return nil, ErrBadTable
+			}
+			samples[s].Index = s
+			samples[s].Chunk = c
+			samples[s].Offset = off
+			samples[s].Size = sizes[s]
+			off += int64(sizes[s])
+			s++
+		}
+	}
+	if s != len(samples) {
+		return nil, ErrBadTable
+	}
+	//}}}
+	//{{{  Decode times from stts
+	var dt uint64
+	s = 0
+	for _, run := range stts {
+		for k := uint32(0); k < run.Count && s < len(samples); k++ {
+			samples[s].DecodeTime = dt
+			dt += uint64(run.Delta)
+			s++
+		}
+	}
+	if s != len(samples) {
+		return nil, ErrBadTable
+	}
+	//}}}
+	//{{{  Presentation times from ctts, if any
+	s = 0
+	for _, run := range ctts {
+		for k := uint32(0); k < run.Count && s < len(samples); k++ {
+			samples[s].PresentationTime = int64(run.Offset)
+			s++
+		}
+	}
+	for i := range samples {
+		samples[i].PresentationTime += int64(samples[i].DecodeTime)
+	}
+	//}}}
+	//{{{  Keyframes from stss: all if absent
+	for _, n := range stss {
+		if n >= 1 && int(n) <= len(samples) {
+			samples[n-1].Keyframe = true
+		}
+	}
+	if !hasStss {
+		for i := range samples {
+			samples[i].Keyframe = true
+		}
+	}
+	//}}}
+	if debug {
+		log.Printf("track %d: %d samples in %d chunks\n",
+			t.ID, len(samples), len(chunks))
+	}
+	return samples, nil
+}
+//}}}
+//{{{  type Chunk -- one chunk of a track, summarised
+// Samples counts those in the chunk, from FirstSample, and Size is their
+// bytes. DecodeTime is that of the first sample, in units of the track
+// Timescale.
+type Chunk struct {
+	Offset		int64
+	FirstSample	int
+	Samples		int
+	Size		int64
+	DecodeTime	uint64
+}
+//}}}
+//{{{  Method Chunks -- resolve the chunks of the track, not every sample
+// For sound, which has many small samples to a chunk, Samples builds
+// millions of entries where the chunks are all that's wanted. Chunks walks
+// stsc, stsz and stts a chunk at a time instead, and never expands a
+// uniform stsz. The tables must agree, as for Samples.
+func (t *Track) Chunks() ([]Chunk, error) {
+	if t.Stbl == nil {
+		return nil, nil
+	}
+	//{{{  Read the tables
+	offsets, err := t.ChunkOffsets()
+	if err != nil {
+		return nil, err
+	}
+	var stsc []StscEntry
+	var stts []SttsEntry
+	var uniform, n uint32
+	var sizes []uint32
+	for _, tb := range []struct {
+		typ	string
+		read	func(*io.SectionReader) error
+	}{
+		{"stsc", func(sr *io.SectionReader) (err error) {
+			stsc, err = ReadStsc(sr); return }},
+		{"stsz", func(sr *io.SectionReader) (err error) {
+			uniform, n, sizes, err = readStsz(sr); return }},
+		{"stts", func(sr *io.SectionReader) (err error) {
+			stts, err = ReadStts(sr); return }},
+	} {
+		sr, err := firstIn(t.Stbl, tb.typ)
+		if err != nil {
+			return nil, err
+		}
+		if sr == nil {
+			continue
+		}
+		if err = tb.read(sr); err != nil {
+			return nil, err
+		}
+	}
+	if len(stsc) == 0 || stsc[0].FirstChunk != 1 {
+		return nil, ErrBadTable
+	}
+	//}}}
+	chunks := make([]Chunk, len(offsets))
+	s := 0			// first sample of the chunk
+	e := 0			// stsc entry
+	run, left := 0, uint32(0)	// stts run, and samples left in it
+	if len(stts) > 0 {
+		left = stts[0].Count
+	}
+	var dt uint64
+	for c := range offsets {
+		for e+1 < len(stsc) && uint32(c+1) >= stsc[e+1].FirstChunk {
+			e++
+		}
+		k := int(stsc[e].SamplesPerChunk)
+		if s+k > int(n) {
+			return nil, ErrBadTable
+		}
+		ch := &chunks[c]
+		ch.Offset, ch.FirstSample, ch.Samples, ch.DecodeTime =
+			int64(offsets[c]), s, k, dt
+		//{{{  Bytes in the chunk
+		if sizes == nil {
+			ch.Size = int64(uniform) * int64(k)
+		} else {
+			for _, size := range sizes[s : s+k] {
+				ch.Size += int64(size)
+			}
+		}
+		//}}}
+		//{{{  Decode time past the chunk, a run at a time
+		for todo := uint32(k); todo > 0; {
+			for left == 0 {
+				if run++; run >= len(stts) {
+					return nil, ErrBadTable
+				}
+				left = stts[run].Count
+			}
+			m := todo
+			if left < m {
+				m = left
+			}
+			dt += uint64(m) * uint64(stts[run].Delta)
+			left -= m
+			todo -= m
+		}
+		//}}}
+		s += k
+	}
+	if s != int(n) {
+		return nil, ErrBadTable
+	}
+	if debug {
+		log.Printf("track %d: %d samples in %d chunks\n", t.ID, n, len(chunks))
+	}
+	return chunks, nil
+}
+//}}}
@@ -0,0 +1,237 @@
+//{{{  license
+// Copyright 2016 KB Sriram
+// Copyright 2018 AE Lawrence
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//}}}
+
+package mov
+
+//{{{  imports
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"time"
+)
+//}}}
+
+var ErrBadHeader = errors.New("Unknown tkhd, mdhd or mvhd version")
+
+//{{{  type Track -- summary of one trak atom
+// Track summarises a trak atom from its tkhd, mdhd and hdlr atoms.
+// Handler is the media handler such as "vide" or "soun", rather than
+// a guess from the media header (vmhd, smhd) present.
+// Duration is in units of Timescale per second.
+// Stbl is the body of the sample table, ready for further decoding:
+// it is nil if the track has none.
+// Created and Modified come from tkhd: see MacTime.
+type Track struct {
+	ID		uint32
+	Handler		string
+	Timescale	uint32
+	Duration	uint64
+	Language	string		// ISO 639-2/T, "" if unknown
+	Created		time.Time
+	Modified	time.Time
+	Stbl		*io.SectionReader
+}
+//}}}
+//{{{  Method Seconds -- duration of the track media
+func (t *Track) Seconds() float64 {
+	if t.Timescale == 0 {
+		return 0
+	}
+	return float64(t.Duration) / float64(t.Timescale)
+}
+//}}}
+
+//{{{  firstIn(sr,selector) -- first match or nil
+func firstIn(sr *io.SectionReader, selector string) (*io.SectionReader, error) {
+	found, err := FindIn(sr, selector)
+	if err != nil || len(found) == 0 {
+		return nil, err
+	}
+	return found[0], nil
+}
+//}}}
+//{{{  language(code) -- unpack mdhd language
+// Quicktime packs ISO 639-2/T as three 5 bit letters offset from 0x60.
+// Values below 0x400 are old Macintosh language codes, of which only
+// 0 (English) is common in camera files.
+func language(code uint16) string {
+	switch {
+	case code == 0:
+		return "eng"
+	case code < 0x400:
+		return ""
+	}
+	return string([]byte{
+		byte(code>>10&0x1f) + 0x60,
+		byte(code>>5&0x1f) + 0x60,
+		byte(code&0x1f) + 0x60,
+	})
+}
+//}}}
+//{{{  readTimes(sr,version) -- creation & modification, either version
+// Version 1 headers have 64 bit times, version 0 32 bit. sr is left
+// at the field following the times.
+func readTimes(sr *io.SectionReader, version uint32) (created, modified time.Time,
+			err error) {
+	switch version {
+	case 0	:
+		var ts [2]uint32
+		if err = binary.Read(sr, binary.BigEndian, &ts); err != nil {
+			return
+		}
+		return MacTime(uint64(ts[0])), MacTime(uint64(ts[1])), nil
+	case 1	:
+		var ts [2]uint64
+		if err = binary.Read(sr, binary.BigEndian, &ts); err != nil {
+			return
+		}
+		return MacTime(ts[0]), MacTime(ts[1]), nil
+	}
+	err = ErrBadHeader
+	return
+}
+//}}}
+//{{{  readTkhd(sr,t) -- track ID and times
+// Version 1 has 64 bit times and duration. Both have the ID
+// after the times.
+func readTkhd(sr *io.SectionReader, t *Track) error {
+	var vf uint32
+	if err := binary.Read(sr, binary.BigEndian, &vf); err != nil {
+		return err
+	}
+	var err error
+	if t.Created, t.Modified, err = readTimes(sr, vf>>24); err != nil {
+		return err
+	}
+	return binary.Read(sr, binary.BigEndian, &t.ID)
+}
+//}}}
+//{{{  readMdhd(sr,t) -- timescale, duration & language
+func readMdhd(sr *io.SectionReader, t *Track) error {
+	var vf uint32
+	var lang uint16
+	if err := binary.Read(sr, binary.BigEndian, &vf); err != nil {
+		return err
+	}
+	switch vf >> 24 {
+	case 0	:
+		var h struct {
+			_		[8]byte		// creation & modification
+			Timescale	uint32
+			Duration	uint32
+		}
+		if err := binary.Read(sr, binary.BigEndian, &h); err != nil {
+			return err
+		}
+		t.Timescale, t.Duration = h.Timescale, uint64(h.Duration)
+	case 1	:
+		var h struct {
+			_		[16]byte	// creation & modification
+			Timescale	uint32
+			Duration	uint64
+		}
+		if err := binary.Read(sr, binary.BigEndian, &h); err != nil {
+			return err
+		}
+		t.Timescale, t.Duration = h.Timescale, h.Duration
+	default	: return ErrBadHeader
+	}
+	if err := binary.Read(sr, binary.BigEndian, &lang); err != nil {
+		return err
+	}
+	t.Language = language(lang)
+	return nil
+}
+//}}}
+//{{{  readTrack(trak) -- build a Track from the trak body
+func readTrack(trak *io.SectionReader) (Track, error) {
+	var t Track
+	//{{{  tkhd
+	sr, err := firstIn(trak, "tkhd")
+	if err != nil {
+		return t, err
+	}
+	if sr != nil {
+		if err = readTkhd(sr, &t); err != nil {
+			return t, err
+		}
+	}
+	//}}}
+	//{{{  mdhd
+	if sr, err = firstIn(trak, "mdia/mdhd"); err != nil {
+		return t, err
+	}
+	if sr != nil {
+		if err = readMdhd(sr, &t); err != nil {
+			return t, err
+		}
+	}
+	//}}}
+	//{{{  hdlr -- mdia's, not the data handler in minf
+	if sr, err = firstIn(trak, "mdia/hdlr"); err != nil {
+		return t, err
+	}
+	if sr != nil {
+		if t.Handler, err = predicateValues["hdlr"](sr); err != nil {
+			return t, err
+		}
+	}
+	//}}}
+	t.Stbl, err = firstIn(trak, "mdia/minf/stbl")
+	return t, err
+}
+//}}}
+//{{{  Tracks(rs) -- summaries of all the tracks in rs
+// Tracks returns a Track for each trak in the moov atom of rs, in file
+// order. Like VisitAtoms, it has the side effect of Seeking to the end of rs.
+// A trak which can't be read, say a timecode track with a header version
+// not known here, is skipped rather than losing the others.
+func Tracks(rs ReadAtSeeker) ([]Track, error) {
+	traks, err := Find(rs, "moov/trak")
+	if err != nil {
+		return nil, err
+	}
+	tracks := make([]Track, 0, len(traks))
+	for i, trak := range traks {
+		t, err := readTrack(trak)
+		if err != nil {
+			if debug {
+				log.Printf("trak %d skipped: %v\n", i, err)
+			}
+			continue
+		}
+		if debug {
+			log.Printf("track %d: %q timescale %d duration %d lang %q\n",
+				t.ID, t.Handler, t.Timescale, t.Duration, t.Language)
+		}
+		tracks = append(tracks, t)
+	}
+	return tracks, nil
+}
+//}}}
+//{{{  FirstTrack(tracks,handler) -- first track with that handler, or nil
+func FirstTrack(tracks []Track, handler string) *Track {
+	for i := range tracks {
+		if tracks[i].Handler == handler {
+			return &tracks[i]
+		}
+	}
+	return nil
+}
+//}}}
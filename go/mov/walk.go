@@ -37,6 +37,7 @@ func SetDebug(incomingdebug bool) {
 var ErrAtomTooLarge = errors.New("Sorry, can't handle enormous atom")
 //{{{  type Visitor interface- Visit methods
 // The Visit method is invoked for each Atom encountered by VisitAtoms.
+// Returning SkipAtom from Visit prevents descent into that atom.
 type Visitor interface {
 	Visit([]string, *io.SectionReader) error
 }
@@ -129,6 +130,62 @@ func nextAtom(sr *io.SectionReader) (string, *io.SectionReader, error) {
 	return string(atyp), io.NewSectionReader(sr, cur, int64(sz)), nil
 }
 //}}}
+//{{{  WalkOptions -- which atoms are containers, how deep to go
+// WalkOptions controls the descent made by VisitAtomsWith.
+// Containers lists the atom types whose bodies are themselves atom lists.
+// FullBoxes is the subset of those which may start with a 4 byte
+// version/flags word before the contained atoms (ISO "full box"), as
+// meta does in mp4 files. Quicktime writes meta without that word, so
+// the version/flags word is only skipped when it is zero. MaxDepth limits
+// the length of the path handed to Visit: 0 means no limit.
+type WalkOptions struct {
+	Containers	map[string]bool
+	FullBoxes	map[string]bool
+	MaxDepth	int
+}
+//}}}
+//{{{  SkipAtom -- returned by Visit to avoid descent
+// SkipAtom may be returned by a Visit method to prevent descent into the
+// atom just visited. The walk continues with the next sibling atom.
+// It is never returned by VisitAtoms or VisitAtomsWith.
+var SkipAtom = errors.New("skip this atom")
+//}}}
+//{{{  DefaultOptions() -- the original container list
+// DefaultOptions returns a fresh copy of the options used by VisitAtoms.
+// The caller may add or remove containers without affecting anyone else.
+// Container atoms not included by default: meta, edts, gmhd
+func DefaultOptions() *WalkOptions {
+	opts := &WalkOptions{
+		Containers: make(map[string]bool),
+		FullBoxes:  map[string]bool{"meta": true},
+	}
+	// Add udta below to see whether firmware version is there....
+	// nb udta has @fmt & @inf embedded atoms.
+	//   '@fmt -> Nextbase, @inf (old :just model, new: firmware version)
+	for _, c := range []string{"moov", "trak", "mdia", "minf", "stbl",
+					"dinf", "udta"} {
+		opts.Containers[c] = true
+	}
+	return opts
+}
+//}}}
+//{{{  skipFullBox(sr) -- step over a zero version/flags word
+// Only used for FullBoxes. Leaves sr alone unless there is a zero word.
+func skipFullBox(sr *io.SectionReader) error {
+	var vf uint32
+	if err := binary.Read(sr, binary.BigEndian, &vf); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			_, err = sr.Seek(0, io.SeekStart)
+		}
+		return err
+	}
+	if vf == 0 {
+		return nil
+	}
+	_, err := sr.Seek(0, io.SeekStart)
+	return err
+}
+//}}}
 //{{{  visitAtomList
 // sr points to an "outer" atom in a file or within another atom.
 // It enters that atom to read the header, and update the sr position
@@ -138,7 +195,8 @@ func nextAtom(sr *io.SectionReader) (string, *io.SectionReader, error) {
 // atoms.
 // The root is a path through the parent atoms.
 
-func visitAtomList(root []string, v Visitor, sr *io.SectionReader) error {
+func visitAtomList(root []string, v Visitor, sr *io.SectionReader,
+			opts *WalkOptions) error {
 	if debug {
 		log.Printf("Visiting at %v\n",root)
 	}
@@ -151,24 +209,38 @@ func visitAtomList(root []string, v Visitor, sr *io.SectionReader) error {
 				return err
 			}
 		}
-		err = v.Visit(append(root, ctype), csr) // process contents
+		//{{{  Copy path so that Visitors may retain it
+		path := make([]string, len(root)+1)
+		copy(path, root)
+		path[len(root)] = ctype
+		//}}}
+		err = v.Visit(path, csr) // process contents
+		if err == SkipAtom {
+			continue
+		}
 		if err != nil {
 			return err
 		}
 
 		//{{{  Explore relevant container atoms
-		// Container atoms not included: meta
-		switch ctype {
-		// case "moov", "trak", "mdia", "minf", "stbl", "dinf":
-		// Add udta below to see whether firmware version is there....
-		// nb udta has @fmt & @inf embedded atoms.
-		//   '@fmt -> Nextbase, @inf (old :just model, new: firmware version)
-		case "moov", "trak", "mdia", "minf", "stbl", "dinf", "udta":
-			err = visitAtomList(append(root, ctype), v, csr)
-			if err != nil {
+		if !opts.Containers[ctype] ||
+			(opts.MaxDepth > 0 && len(path) >= opts.MaxDepth) {
+			continue
+		}
+		//{{{  Visit may have moved csr, so rewind
+		if _, err = csr.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		//}}}
+		if opts.FullBoxes[ctype] {
+			if err = skipFullBox(csr); err != nil {
 				return err
 			}
 		}
+		err = visitAtomList(path, v, csr, opts)
+		if err != nil {
+			return err
+		}
 		//}}}
 	}
 }
@@ -192,11 +264,22 @@ func visitAtomList(root []string, v Visitor, sr *io.SectionReader) error {
 //}}}
 
 func VisitAtoms(v Visitor, rs ReadAtSeeker) error {
+	return VisitAtomsWith(v, rs, DefaultOptions())
+}
+//}}}
+//{{{  VisitAtomsWith (v,rs,opts) -- as VisitAtoms, but under control of opts
+// VisitAtomsWith is VisitAtoms with the containers and depth taken from
+// opts rather than the defaults. A nil opts means DefaultOptions().
+func VisitAtomsWith(v Visitor, rs ReadAtSeeker, opts *WalkOptions) error {
+	if opts == nil {
+		opts = DefaultOptions()
+	}
 	len, err := seekEnd(rs)
 	if err != nil {
 		return err
 	}
-	return visitAtomList(make([]string, 0), v, io.NewSectionReader(rs, 0, len))
+	return visitAtomList(make([]string, 0), v,
+				io.NewSectionReader(rs, 0, len), opts)
 }
 //}}}
 
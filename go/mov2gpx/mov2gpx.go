@@ -20,18 +20,21 @@ package main
 //{{{  imports
 
 import (
-	"bufio"
-	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
+	"github.com/clarified/mov2gps/go/gpx"
+	"github.com/clarified/mov2gps/go/mov"
 	"github.com/clarified/mov2gps/go/nb"
+	"github.com/clarified/mov2gps/go/track"
+	"io"
 	"log"
-	"math"
 	"os"
 	"path/filepath"
 	"strings"
-	"strconv"
+	"time"
 )
 //}}}
 const version = "1"
@@ -51,6 +54,40 @@ var (
 	verFlag = flag.Bool("V", false, "Display version")
 	rubbish = flag.Bool("clean", true, 
 		"Remove dubious points at sea with lat/long = 0/0")
+	clock = flag.Bool("clock", false,
+		"Record camera clock offset from GPS time in gpx metadata")
+	recovery = flag.Bool("recover", false,
+		"Ignore the sample tables and scan the whole file for GPS records")
+	report = flag.Bool("report", false,
+		"Also write an evidence report: name.report.json and name.report.txt")
+	void = flag.String("void", "keep",
+		"Points the receiver marks void (V): keep, drop or flag with <cmt>")
+	outliers = flag.String("outliers", "off",
+		"Points needing impossible speed or acceleration: off, drop or repair")
+	maxSpeed = flag.Float64("maxspeed", 250,
+		"With -outliers: the fastest plausible speed, km/h")
+	maxAccel = flag.Float64("maxaccel", 10,
+		"With -outliers: the largest plausible acceleration, m/s²")
+	smooth = flag.Bool("smooth", false,
+		"Smooth the track: Kalman filter and RTS smoother, using hdop, speed and course")
+	simplify = flag.Float64("simplify", 0,
+		"Leave out points within this many metres of the simplified track, 0 for none")
+	simplifyBy = flag.String("simplifyby", "dp",
+		"With -simplify: dp (Douglas-Peucker) or vw (Visvalingam-Whyatt)")
+	resample = flag.String("resample", "",
+		"Put points at each step of media time, e.g. 100ms, or 'frame' for each video frame")
+	interp = flag.String("interp", "linear",
+		"With -resample: linear or gc (great circle) interpolation of position")
+	every = flag.Duration("every", 0,
+		"Keep only the first point in each step of this long, e.g. 5s")
+	splitGap = flag.Duration("splitgap", 0,
+		"Start a new <trkseg> after a gap in GPS time longer than this, e.g. 10s")
+	splitJump = flag.Float64("splitjump", 0,
+		"Start a new <trkseg> where the track jumps further than this, metres")
+	fill = flag.Duration("fill", 0,
+		"Fill gaps in GPS time up to this long with points marked <m2g:synthetic>")
+	stats = flag.String("stats", "",
+		"Instead of gpx, write trip statistics to stdout: text or json")
 )
 //}}}
 //{{{  usage
@@ -75,12 +112,55 @@ func main() {
 		usage()
 	}
 
+	switch *void {
+	case "keep", "drop", "flag":
+	default:
+		fmt.Fprintf(os.Stderr, "-void must be keep, drop or flag\n\n")
+		usage()
+	}
+	switch *outliers {
+	case "off", "drop", "repair":
+	default:
+		fmt.Fprintf(os.Stderr, "-outliers must be off, drop or repair\n\n")
+		usage()
+	}
+	switch *simplifyBy {
+	case "dp", "vw":
+	default:
+		fmt.Fprintf(os.Stderr, "-simplifyby must be dp or vw\n\n")
+		usage()
+	}
+	switch *interp {
+	case "linear", "gc":
+	default:
+		fmt.Fprintf(os.Stderr, "-interp must be linear or gc\n\n")
+		usage()
+	}
+	switch *stats {
+	case "", "text", "json":
+	default:
+		fmt.Fprintf(os.Stderr, "-stats must be text or json\n\n")
+		usage()
+	}
+	if *resample != "" && *resample != "frame" {
+		if d, err := time.ParseDuration(*resample); err != nil || d <= 0 {
+			fmt.Fprintf(os.Stderr, "-resample must be a duration, like 100ms, or frame\n\n")
+			usage()
+		}
+	}
+
 	// Try to give clicky-pointy types a clue:
 	if flag.NArg() == 0 {
 		usage()
 	}
 
 	nb.SetDebug(*debug)
+	if *stats != "" {
+		if err := statistics(flag.Args()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	for i := 0; i < flag.NArg(); i++ {
 		if err := process(flag.Arg(i)); err != nil {
 			log.Fatal(err)
@@ -102,6 +182,7 @@ func process(movPath string) error {
 
 	var stdout bool
 	var gpxPath string
+	reportBase := movPath[:len(movPath)-len(ext)]	// if gpx to stdout
 
 	switch *Odir {
 	case ""  : gpxPath = movPath[:len(movPath)-len(ext)]
@@ -111,9 +192,10 @@ func process(movPath string) error {
 	}
 
 	if !stdout {
+		reportBase = gpxPath
 		gpxPath = gpxPath + ".gpx"
 		if *verbose && *Odir != "" {
-			fmt.Fprintf(os.Stderr,fmt.Sprintf("Writing to %s\n", gpxPath))
+			fmt.Fprintf(os.Stderr, "Writing to %s\n", gpxPath)
 		}
 	}
 	//}}}
@@ -132,22 +214,39 @@ func process(movPath string) error {
 	}
 	defer movFile.Close()
 
-	var out *bufio.Writer
+	var w io.Writer
 
 	switch {
-	case stdout : out = bufio.NewWriter(os.Stdout)
+	case stdout : w = os.Stdout
 	default     :
 		gpxFile, err := os.Create(gpxPath)
 		if err != nil {
 			return err
 		}
 		defer gpxFile.Close()
-		out = bufio.NewWriter(gpxFile)
+		w = gpxFile
 	}
-	defer out.Flush()
+	//{{{  -report: hash the gpx as it is written
+	gpxHash := sha256.New()
+	if *report {
+		w = io.MultiWriter(w, gpxHash)
+	}
+	//}}}
+	out := gpx.NewWriter(w, "mov2gpx")
+	out.Version, out.NoNMEA = *gpxVersion, *noNMEA
+	out.FlagVoid, out.Debug = *void == "flag", *debug
+	out.Synthetic, out.Repaired = *fill > 0, *outliers == "repair"
+	out.Interpolated = *resample != ""
 	//}}}
 
-	gpsLogs, udata, err := nb.NewInfo(movFile).GPSLogs()
+	//{{{  Without moov, NewInfo scans anyway: -recover forces that
+	var info nb.GPSInfo
+	switch {
+	case *recovery	: info = nb.NewRecoveryInfo(movFile)
+	default		: info = nb.NewInfo(movFile)
+	}
+	//}}}
+	gpsLogs, udata, err := info.GPSLogs()
 	if err != nil {
 		return err
 	}
@@ -157,356 +256,250 @@ func process(movPath string) error {
 						movPath,(*udata).Inf,(*udata).Fmt))
 	}
 	//}}}
-
-	writeHeader(out)
-	for _, gpsLog := range gpsLogs {
-		if e := writePoint(out, &gpsLog); e != nil {
-			return e
-		}
-	}
-	writeFooter(out)
-	return nil
-}
-//}}}
-//{{{  writePoint(w,gpsLog) error
-// https://en.wikipedia.org/wiki/GPS_Exchange_Format
-// & the gpx xsd schemas.
-
-//{{{  RMC index offsets
-// Unfortunately the RMC entries are sometimes truncated and
-// corrupted, so we have the -x flag to avoid. And try to set if 
-//  problems detected. The main reason for keeping is for debug
-//  investigation of new dashcams/firmware
-
-const (
-	rUTC	= iota	// hour-min-sec without separators
-	rValid	= iota	// A - valid, V - warning
-	rLat	= iota	// Latitude
-	rNorS	= iota	// North or South (N|S)
-	rLong	= iota	// Longitude
-	rEorW	= iota	// East or West (E|W)
-	rSpeed	= iota	// Speed in knots (1.852 kM/hr)
-	rCourse	= iota	// Course (degrees, true)
-	rDate	= iota	// Date (ddmmyy)
-	_	= iota	// Mag variation
-	_	= iota	// (E|W) of variation
-	_	= iota	// Checksum & trailing zeros
-)
-//}}}
-//{{{  GGA index offsets
-
-const (
-	gUTC	= iota	// hour-min-sec without separators
-	gLat	= iota	// Latitude
-	gNorS	= iota	// North or South (N|S)
-	gLong	= iota	// Longitude
-	gEorW	= iota	// East or West (E|W)
-	gQual	= iota	// 0 for no fix, 1 for gps, 2 for dgps
-	gSat	= iota	// No of satelites in use
-	gHdop	= iota	// Horizontal dilution of prec.
-	gHeight	= iota	// Height
-	gHUnit	= iota	// Height unit (Metres normally)
-	gGeoid	= iota	// Geoid separation
-	gGUnit	= iota	// Geo sep unit (Metres normally)
-	_	= iota	// Differential relevance?
-	_	= iota	// Checksum & trailing zeros
-)
-//}}}
-
-func writePoint(w *bufio.Writer, gpsLog *nb.GPSLog) error {
-// Not actually using error at present, so could remove?
-
-	//{{{  Do nothing if a rubbish point
-	// Real gps videos always seem to have "GPS ", even when rubbish points.
-	// Testing for GPS is worthwhile when fed non gps video
-	if string(gpsLog.Magic[:]) != "GPS " ||
-		(*rubbish && gpsLog.Latitude == 0 && gpsLog.Longitude == 0 )||
-		gpsLog.Mon == 0  {
-		return nil
+	//{{{  Found by scanning: no timing from the sound track
+	if info.Scanned() && (*verbose || *debug) {
+		fmt.Fprintf(os.Stderr, "\tNo usable sample tables: %d GPS blocks found by scanning\n",
+			len(gpsLogs))
 	}
 	//}}}
-	localNo := *noNMEA // Could leave at default
-	//{{{  Set flag(s) if RMC/GGA records seen: if so slice around ","
-	// RMC has speed, but we need conversion from knots, so no advantage
-	// over direct value. Include in debug in case some dash cam
-	// only includes RMC. Likewise parse gga even when noNMEA so that
-	// debug can probe unknown firmware/dashcams & examine corrupt
-	// entries.
-
-	rmc := string(gpsLog.MagicRMC[:]) == "$GPRMC,"
-	gga := string(gpsLog.MagicGGA[:]) == "$GPGGA,"
-
-	// It is very likely that the rmc and gga flags are the same for all points in
-	// a single file. If that is so, an obvious optimization is to 
-	// set these at the outer "file" level.
-
-	// Do the slice separation here (minimal parse)
-	var ggaSlices [][]byte
-
-	//{{{  COMMENT rmcSlices not used for now
-	//if rmc && *debug {
-	//        rmcSlices = bytes.Split(gpsLog.RMCentries[:],[]byte(`,`))
-	//}
-	//}}}
-	if gga {
-		ggaSlices = bytes.Split(gpsLog.GGAentries[:],[]byte(`,`))
-		// Unfortunately some entries can be unexpectedly blank
-		//  so we need various tedious checks later
-
-		// RMC entries can be corrupt, so check GGA at least isn't truncated
-		localNo = *noNMEA || len(ggaSlices) < 14
+	//{{{  Sound chunks without a GPS block
+	if missing := info.Missing(); len(missing) > 0 && (*verbose || *debug) {
+		fmt.Fprintf(os.Stderr, "\tGPS blocks missing: %d of %d, after sound chunks %v\n",
+			len(missing), len(gpsLogs), missing)
 	}
-
 	//}}}
-	//{{{  Lat,Lon attributes
-	// Could get from RMC or GAA, but still requires some reformatting, so little
-	// advantage. But if there are any dash-cams that only provide RMC or GGA
-	// this might need modifying.
-
-	w.WriteString(fmt.Sprintf(`
-      <trkpt lat="%.6f" lon="%.6f">`,
-		toDD(gpsLog.LatitudeSpec, gpsLog.Latitude),
-		toDD(gpsLog.LongitudeSpec, gpsLog.Longitude)))
+	//{{{  Camera's displayed local time zone
+	// Summer time is judged by this machine's zone: there is nothing
+	// better to go on.
+	if *verbose || *debug {
+		if zone, ok := nb.InferZone(gpsLogs, time.Local); ok {
+			summer := ""
+			if zone.Summer {
+				summer = " (summer time)"
+			}
+			fmt.Fprintf(os.Stderr, "\tCamera local time: %v%s\n",
+				zone, summer)
+		}
+	}
 	//}}}
-	//{{{  SpeedCourse(first) -- anon so closure.
-	var speedCourse func(bool) = func(first bool) {
-
-		//{{{  Do nothing if first does not match gpxVersion
-		// When first is true, only active for gpx1.0.
-		//  If first is false, only active for gpx1.1
-		// If anyone has set -g negative, they deserve what they get!
-		if (!first && *gpxVersion == 0 ) || (first && *gpxVersion == 1 ) {
-		      return
+	//{{{  Camera clock against GPS
+	var desc string
+	if *verbose || *debug || *clock {
+		if desc, err = cameraClock(movFile, info); err != nil {
+			return err
 		}
-		//}}}
-
-		var prefix,speed,course,courseVal,ctag string
-		var testCourse bool
-
-		//{{{  open extension if 1.1 & set prefix while we are here
-		if *gpxVersion == 1 {
-		      w.WriteString(`
-	<extensions>
-	  <gpxtpx:TrackPointExtension>`)
-
-		prefix = "gpxtpx:"
+		if (*verbose || *debug) && desc != "" {
+			fmt.Fprintf(os.Stderr, "\t%s\n", desc)
 		}
-		//}}}
-
-		//{{{  build speed
-		// speed comes in knots, convert to m/s
-		const knotsToMpersec = 1852.0/3600.0	//Decimal to get float64
-
-		//{{{  COMMENT Simple speed, but multiple string copies
-		//     speed = `
-		//<` + prefix + `speed>` +
-		//     fmt.Sprintf("%.6f", (gpsLog.Speed)*knotsToMpersec) +  `</` +
-		//     prefix + `speed>`
-		//}}}
-		//{{{  Builder:  marginally more efficient way to build speed
-		stag := prefix + `speed`
-		var sb strings.Builder
-
-		sb.WriteString(`
-	   <`)
-		sb.WriteString(stag)
-		sb.WriteString(`>`)
-		sb.WriteString( fmt.Sprintf("%.6f", (gpsLog.Speed)*knotsToMpersec))
-		sb.WriteString(`</`)
-		sb.WriteString(stag)
-		sb.WriteString(`>`)
-		speed = sb.String()
-
-		//}}}
-		//}}}
-		//{{{  course & testCourse
-		// At low speeds, 0 values for course appear to mean unknown, so
-		// skip such points
-		// It turns out that RMC can be truncated, so rather than
-		// do checks for validity, just collect from main log
-		testCourse = gpsLog.Speed > 2 || gpsLog.Course > 0.00001
-		if testCourse {
-			courseVal = fmt.Sprintf("%.6f",gpsLog.Course)
+		if !*clock {
+			desc = ""
 		}
+	}
+	//}}}
 
-		// Probably not worth using a Builder for course
-		ctag   = prefix + `course>`
-		course = `
-	   <` + ctag + courseVal + `</` + ctag
-
-		//}}}
-
-	      if *gpxVersion == 1 {
-		      w.WriteString(speed)
-		      if testCourse {
-			      w.WriteString(course)
-		      }
-	      } else {
-		      if testCourse {
-			      w.WriteString(course)
-		      } 
-		      w.WriteString(speed)
-	      }
-
-	      //{{{  Close extension for 1.1
-	      if *gpxVersion == 1 {
-		      w.WriteString(`
-	  </gpxtpx:TrackPointExtension>
-       </extensions>`)
-	      }
-	      //}}}
-	}
-      //}}}
-
-	//{{{  debug for RMC,GGA
-	  // Buried in lang spec under Conversions: strings <-> byte slices only
-	  // See subsection : Conversions to and from a string type
-
+	decoded, err := cleanFixes(info)
+	if err != nil {
+		return err
+	}
+	//{{{  Integrity of the fixes as decoded
+	// Before any processing, which would hide what the receiver gave.
+	integrity := nb.Check(decoded)
+	if *verbose || *debug {
+		fmt.Fprintf(os.Stderr, "\tIntegrity: %v\n", integrity)
+	}
 	if *debug {
-		if rmc {
-			log.Printf("RMC present: RMC  %s\n", gpsLog.RMCentries)
+		for i, f := range integrity.Flags {
+			if f != 0 {
+				log.Printf("%v at %#x: %v\n", decoded[i].Time, decoded[i].Offset, f)
+			}
 		}
-
-		if gga {
-			log.Printf("GGA present: ggaSlices = %s\n", ggaSlices)
+	}
+	//}}}
+	fixes := stages(decoded)
+	//{{{  Segments, each resampled and simplified on its own
+	// so that neither reaches across a gap.
+	segments := [][]nb.Fix{fixes}
+	if len(fixes) > 0 && (*splitGap > 0 || *splitJump > 0) {
+		segments = track.Split(fixes, *splitGap, *splitJump)
+		if *verbose || *debug {
+			fmt.Fprintf(os.Stderr, "\tSegments: %d\n", len(segments))
 		}
 	}
+	var step time.Duration
+	if *resample != "" {
+		if step, err = resampleStep(movFile); err != nil {
+			return err
+		}
+	}
+	n := len(fixes)
+	fixes = nil
+	for i := range segments {
+		segments[i] = shape(segments[i], step)
+		fixes = append(fixes, segments[i]...)
+	}
+	if (*verbose || *debug) && (step > 0 || *every > 0 || *simplify > 0) {
+		fmt.Fprintf(os.Stderr, "\tPoints: %d from %d fixes\n", len(fixes), n)
+	}
 	//}}}
-
-       //{{{  <ele>
-       // Height in metres (no idea what other units can occur in gHUnit)
-       // gHeight can sometimes be empty which leads to a strictly invalid
-       // gpx file. 
-       if !localNo && gga {
-		height := string(ggaSlices[gHeight])
-		if height != "" && bytes.Equal(ggaSlices[gHUnit], []byte{'M'}) {
-			w.WriteString(fmt.Sprintf(`
-	<ele>%s</ele>`, height))
+	if err = out.Header(desc); err != nil {
+		return err
+	}
+	if err = out.Track("", segments...); err != nil {
+		return err
+	}
+	if err = out.Footer(); err != nil {
+		return err
+	}
+	if !*report {
+		return nil
+	}
+	//{{{  Evidence report
+	o := outputFile{Path: gpxPath, SHA256: hex.EncodeToString(gpxHash.Sum(nil)),
+		Points: len(fixes)}
+	if stdout {
+		o.Path = "-"
+	}
+	r, err := buildReport(movPath, movFile, info, decoded, fixes, integrity, o)
+	if err != nil {
+		return err
+	}
+	return writeReport(reportBase, r)
+	//}}}
+}
+//}}}
+//{{{  dropVoid(fixes) -- without the points the receiver marked V
+func dropVoid(fixes []nb.Fix) []nb.Fix {
+	kept := fixes[:0:0]
+	for _, fix := range fixes {
+		if fix.Status != 'V' {
+			kept = append(kept, fix)
 		}
 	}
+	return kept
+}
 //}}}
-       //{{{  <time>
-       //{{{ Time should (must) be in UTC, formatted as ISO8601 
-       // sggps did something strange here.
-       // It assumed that the time read from the MOV was local
-       // rather than UTC. Maybe some dashcams do that: after all
-       // the displayed value in the video is local.
-       // But Nextbase, at least, seem to use UTC in the gps record.
-
-       // The gpx specfication requires that the time is UTC
-       // and formatted according to ISO 8601. sggps conformed to
-       // ISO 8601, but violated gpx by using local time in the gpx.
-       // The original sggps code looked like:
-       //	_, offset := time.Now().Zone()
-       //	var neg byte
-       //	if offset < 0 {
-       //		neg = '-'
-       //		offset = -offset
-       //	} else {
-       //		neg = '+'
-       //	}
-       //
-       //	hOffset := offset / 3600
-       //	sOffset := offset % 3600
-
-       //}}}
-       w.WriteString(fmt.Sprintf(`
-        <time>%4d-%02d-%02dT%02d:%02d:%02dZ</time>`,
-	2000+int(gpsLog.Year), int(gpsLog.Mon), int(gpsLog.Day),
-	int(gpsLog.Hour), int(gpsLog.Min), int(gpsLog.Sec),
-	))
+//{{{  cleanFixes(info) -- the fixes decoded, less those not wanted at all
+// What the receiver gave, with nothing moved or made up: for the
+// integrity check and the evidence report.
+func cleanFixes(info nb.GPSInfo) ([]nb.Fix, error) {
+	fixes, _, err := info.Fixes()
+	if err != nil {
+		return nil, err
+	}
+	if *rubbish {
+		fixes = nb.Clean(fixes)
+	}
+	if *void == "drop" {
+		fixes = dropVoid(fixes)
+	}
+	return fixes, nil
+}
 //}}}
-	speedCourse(true)
-	if !localNo {
-	       //{{{  <geoidheight>
-		if gga {
-			geoid := ggaSlices[gGeoid]
-			if len(geoid) > 0 &&
-				bytes.Equal(ggaSlices[gGUnit], []byte{'M'}) {
-				w.WriteString(fmt.Sprintf(`
-	<geoidheight>%s</geoidheight>`, geoid))
+//{{{  stages(fixes) -- the clean fixes filtered, smoothed and filled as asked
+// Everything after cleanFixes up to splitting into segments.
+func stages(fixes []nb.Fix) []nb.Fix {
+	//{{{  Outliers
+	if *outliers != "off" {
+		var removed []track.Outlier
+		lim := track.Limits{MaxSpeed: *maxSpeed / 3.6, MaxAccel: *maxAccel,
+			Repair: *outliers == "repair"}
+		fixes, removed = track.Filter(fixes, lim)
+		if *verbose || *debug {
+			fmt.Fprintf(os.Stderr, "\tOutliers: %d\n", len(removed))
+			for _, o := range removed {
+				fmt.Fprintf(os.Stderr, "\t  %v\n", o)
 			}
 		}
-	       //}}}
-	       //{{{  <sat>
-		if gga && *gpxVersion == 0 {
-			sat := ggaSlices[gSat]
-			if len(sat) > 0 {
-				w.WriteString(fmt.Sprintf(`
-	<sat>%s</sat>`, sat))
-			}
-		}
-	       //}}}
-	       //{{{  <hdop>
-	       if gga  {
-		       hdop := ggaSlices[gHdop]
-		       if len(hdop) > 0 {
-			       w.WriteString(fmt.Sprintf(`
-	<hdop>%s</hdop>`, ggaSlices[gHdop]))
-			}
+	}
+	//}}}
+	if *smooth {
+		fixes = track.Smooth(fixes, track.DefaultSmoothing())
+	}
+	if *fill > 0 {
+		var added int
+		fixes, added = track.Fill(fixes, *fill)
+		if *verbose || *debug {
+			fmt.Fprintf(os.Stderr, "\tFilled: %d synthetic points\n", added)
 		}
-		//}}}
 	}
-	speedCourse(false)
-
-      //{{{  /trkpt
-	w.WriteString(`
-     </trkpt>`)
-      //}}}
-
-	return nil
+	return fixes
 }
 //}}}
-//{{{  toDD(spec,v) float32
-// Input comes as decimal minutes
-func toDD(spec byte, v float32) float32 {
-	deg, frac := math.Modf(float64(v) / 100)
-	result := float32(deg + frac/0.6)
-	if spec == 'S' || spec == 'W' {
-		result = -result
-	}
-	return result
+//{{{  shape(fixes,step) -- resampled, downsampled and simplified as asked
+// step is from -resample, 0 for none.
+func shape(fixes []nb.Fix, step time.Duration) []nb.Fix {
+	if step > 0 {
+		how := track.Linear
+		if *interp == "gc" {
+			how = track.GreatCircle
+		}
+		fixes = track.Resample(fixes, step, how)
+	}
+	if *every > 0 {
+		fixes = track.Downsample(fixes, *every)
+	}
+	if *simplify > 0 {
+		method := track.DouglasPeucker
+		if *simplifyBy == "vw" {
+			method = track.Visvalingam
+		}
+		fixes = track.Simplify(fixes, *simplify, method)
+	}
+	return fixes
 }
 //}}}
-//{{{  writeHeader(w) error
-func writeHeader(w *bufio.Writer) error {
-
-	gver := strconv.Itoa(*gpxVersion)
-
-	_, err := w.WriteString(`<?xml version="1.0" encoding="UTF-8" ?>
-<gpx
- xmlns="http://www.topografix.com/GPX/1/`)
- 
-	_, err = w.WriteString(gver)
-	_, err = w.WriteString( `"
- xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"
- xsi:schemaLocation="http://www.topografix.com/GPX/1/`)
-	_, err = w.WriteString(gver)
-	_, err = w.WriteString( ` http://www.topografix.com/GPX/1/`)
-	_, err = w.WriteString(gver)
-	_, err = w.WriteString( `/gpx.xsd"
-`)
-	if *gpxVersion == 1 {
-		_, err = w.WriteString(
-		  ` xmlns:gpxtpx="http://www.garmin.com/xmlschemas/TrackPointExtension/v2"
-`)
-	}
-	_, err = w.WriteString( ` version="1.`)
-	_, err = w.WriteString(gver)
-	_, err = w.WriteString(`"
- creator="mov2gpx">
-  <trk>
-    <trkseg>`)
-	return err
+//{{{  resampleStep(movFile) -- from -resample, a duration or "frame"
+// A frame is the video track duration over its number of samples: the
+// cameras record at a constant rate.
+func resampleStep(movFile *os.File) (time.Duration, error) {
+	if *resample != "frame" {
+		return time.ParseDuration(*resample)
+	}
+	tracks, err := mov.Tracks(movFile)
+	if err != nil {
+		return 0, err
+	}
+	video := mov.FirstTrack(tracks, "vide")
+	if video == nil {
+		return 0, errors.New("-resample frame: no video track")
+	}
+	samples, err := video.Samples()
+	if err != nil {
+		return 0, err
+	}
+	if len(samples) == 0 || video.Seconds() == 0 {
+		return 0, errors.New("-resample frame: no video frames")
+	}
+	return time.Duration(video.Seconds() / float64(len(samples)) * float64(time.Second)), nil
 }
 //}}}
-//{{{  writeFooter(w) error
-func writeFooter(w *bufio.Writer) error {
-	_, err := w.WriteString(`
-    </trkseg>
-  </trk>
-</gpx>
-`)
-	return err
+//{{{  cameraClock(movFile,info) -- describe camera clock offset
+// The camera writes its own clock into mvhd, often local time and
+// sometimes adrift. Returns "" if there is no mvhd time or no fix
+// to compare with. A moov that can't be read is logged, not an error:
+// the fixes came from the blocks, and the gpx can still be written.
+func cameraClock(movFile *os.File, info nb.GPSInfo) (string, error) {
+	movie, err := mov.ReadMovie(movFile)
+	if err == mov.ErrNoMovie {
+		return "", nil
+	}
+	if err != nil {
+		log.Printf("%v: no camera clock: %v\n", movFile.Name(), err)
+		return "", nil
+	}
+	fixes, _, err := info.Fixes()
+	if err != nil {
+		return "", err
+	}
+	offset, ok := nb.ClockOffset(movie.Created, fixes)
+	if !ok {
+		return "", nil
+	}
+	sign := "+"
+	if offset < 0 {
+		sign, offset = "-", -offset
+	}
+	return fmt.Sprintf("Camera clock minus GPS time: %s%v (mvhd %s)",
+		sign, offset, movie.Created.Format("2006-01-02 15:04:05")), nil
 }
 //}}}
@@ -0,0 +1,377 @@
+//{{{  license
+// Copyright 2018,2019 A E Lawrence
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//}}}
+
+package main
+
+//{{{  imports
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/clarified/mov2gps/go/mov"
+	"github.com/clarified/mov2gps/go/nb"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+//}}}
+
+//{{{  Evidence report -- overview
+// With -report, each gpx file gets two companions: name.report.json, a
+// manifest for programs, and name.report.txt, the same for people. They
+// tie every point in the gpx to the bytes it came from: the SHA-256 of
+// the MOV, where each GPS block is in it, and the raw block behind each
+// point, so anyone with the MOV can check the gpx independently.
+// Each block has the fix decoded from it, before -outliers, -smooth,
+// -resample and -fill. Points in the gpx which are not such a fix as it
+// was decoded, but smoothed, interpolated, repaired or synthetic, are
+// listed apart, with the block each came from where there is one.
+//}}}
+
+//{{{  evidence types -- the JSON manifest
+type evidence struct {
+	Tool		string		`json:"tool"`
+	Version		string		`json:"version"`
+	GoVersion	string		`json:"go_version"`
+	Generated	time.Time	`json:"generated"`
+	Input		inputFile	`json:"input"`
+	Decoder		decoder		`json:"decoder"`
+	Output		outputFile	`json:"output"`
+	Integrity	string		`json:"integrity"`
+	Blocks		[]block		`json:"blocks"`
+	Processed	[]processed	`json:"processed_points,omitempty"`
+}
+
+type inputFile struct {
+	Path		string		`json:"path"`
+	Size		int64		`json:"size"`
+	SHA256		string		`json:"sha256"`
+	Comment		string		`json:"udta_comment"`
+	Firmware	string		`json:"udta_format_firmware"`
+	Created		*time.Time	`json:"mvhd_created,omitempty"`
+	Duration	float64		`json:"mvhd_duration_seconds,omitempty"`
+	MovieError	string		`json:"mvhd_error,omitempty"`
+}
+
+// Method says how the blocks were found: after the sound chunks given by
+// the sample tables, or by scanning the whole file.
+type decoder struct {
+	Layout		string		`json:"layout"`
+	Method		string		`json:"method"`
+	BlockSize	int		`json:"block_bytes"`
+	Options		[]string	`json:"options"`
+}
+
+type outputFile struct {
+	Path		string		`json:"path"`
+	SHA256		string		`json:"sha256"`
+	Points		int		`json:"points"`
+}
+
+// A block which decoded to a fix has the point as decoded and the raw
+// bytes behind it. Emitted says whether the gpx has a point from the block,
+// as decoded or processed. Offset is -1 for a sound chunk with no block.
+type block struct {
+	Index		int		`json:"index"`
+	Offset		int64		`json:"offset"`
+	Emitted		bool		`json:"emitted"`
+	Point		*point		`json:"point,omitempty"`
+	Raw		string		`json:"raw_hex,omitempty"`
+}
+
+type point struct {
+	Time		time.Time	`json:"time"`
+	Lat		float64		`json:"lat"`
+	Lon		float64		`json:"lon"`
+	Source		string		`json:"source"`
+	Flags		string		`json:"integrity_flags,omitempty"`
+	RMC		[]string	`json:"rmc_fields,omitempty"`
+	GGA		[]string	`json:"gga_fields,omitempty"`
+}
+
+// A point in the gpx which is not a fix as decoded. Block is the offset of
+// the block it came from, -1 if none.
+type processed struct {
+	Time		time.Time	`json:"time"`
+	Lat		float64		`json:"lat"`
+	Lon		float64		`json:"lon"`
+	Source		string		`json:"source"`
+	Block		int64		`json:"block_offset"`
+}
+//}}}
+
+//{{{  hashFile(f) -- SHA-256 and size of the whole file
+func hashFile(f *os.File) (string, int64, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", 0, err
+	}
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	return hex.EncodeToString(h.Sum(nil)), n, err
+}
+//}}}
+//{{{  options() -- the flags which change what goes in the gpx
+func options() []string {
+	return []string{
+		fmt.Sprintf("gpx=1.%d", *gpxVersion),
+		fmt.Sprintf("clean=%v", *rubbish),
+		fmt.Sprintf("nmea=%v", !*noNMEA),
+		fmt.Sprintf("void=%s", *void),
+		fmt.Sprintf("recover=%v", *recovery),
+		fmt.Sprintf("outliers=%s", *outliers),
+		fmt.Sprintf("smooth=%v", *smooth),
+		fmt.Sprintf("resample=%s,%s", *resample, *interp),
+		fmt.Sprintf("every=%v", *every),
+		fmt.Sprintf("split=%v,%gm", *splitGap, *splitJump),
+		fmt.Sprintf("fill=%v", *fill),
+		fmt.Sprintf("simplify=%gm,%s", *simplify, *simplifyBy),
+	}
+}
+//}}}
+//{{{  provenance(fix) -- how a point differs from the fix decoded, "" if not
+func provenance(fix *nb.Fix) string {
+	var how []string
+	for _, p := range []struct {
+		set	bool
+		name	string
+	}{
+		{fix.Synthetic, "synthetic"},
+		{fix.Interpolated, "interpolated"},
+		{fix.Repaired, "repaired"},
+		{fix.Smoothed, "smoothed"},
+	} {
+		if p.set {
+			how = append(how, p.name)
+		}
+	}
+	return strings.Join(how, ", ")
+}
+//}}}
+//{{{  buildReport(movPath,movFile,info,decoded,points,integrity,out) -- the manifest
+// decoded are the fixes as decoded and cleaned, and checked by integrity.
+// points are those written to the gpx, described by out.
+func buildReport(movPath string, movFile *os.File, info nb.GPSInfo,
+		decoded, points []nb.Fix, integrity nb.Integrity,
+		out outputFile) (*evidence, error) {
+	r := &evidence{
+		Tool:		"mov2gpx",
+		Version:	version,
+		GoVersion:	runtime.Version(),
+		Generated:	time.Now().UTC(),
+		Output:		out,
+		Integrity:	integrity.String(),
+	}
+	r.Input.Path = movPath
+	var err error
+	if r.Input.SHA256, r.Input.Size, err = hashFile(movFile); err != nil {
+		return nil, err
+	}
+	//{{{  udta and mvhd
+	_, udata, err := info.GPSLogs()
+	if err != nil {
+		return nil, err
+	}
+	r.Input.Comment, r.Input.Firmware = string(udata.Inf), string(udata.Fmt)
+	movie, err := mov.ReadMovie(movFile)
+	switch {
+	case err == nil:
+		if !movie.Created.IsZero() {
+			r.Input.Created = &movie.Created
+		}
+		r.Input.Duration = movie.Seconds()
+	case err != mov.ErrNoMovie:
+		// As for -clock: the gpx is written, and the blocks are evidence
+		// enough without mvhd.
+		log.Printf("%v: no mvhd for the report: %v\n", movPath, err)
+		r.Input.MovieError = err.Error()
+	}
+	//}}}
+	//{{{  decoder
+	size := binary.Size(nb.GPSLog{})
+	r.Decoder = decoder{
+		Layout:		"Novatek free/GPS block (nb.GPSLog), little endian",
+		Method:		"after each sound chunk (sample tables)",
+		BlockSize:	size,
+		Options:	options(),
+	}
+	if info.Scanned() {
+		r.Decoder.Method = "scan of whole file (no usable sample tables)"
+	}
+	//}}}
+	//{{{  blocks, with the fix decoded and the raw bytes behind it
+	written := make(map[int64]bool, len(points))
+	for i := range points {
+		if points[i].Offset >= 0 {
+			written[points[i].Offset] = true
+		}
+	}
+	fixes := make(map[int64]*nb.Fix, len(decoded))
+	for i := range decoded {
+		fixes[decoded[i].Offset] = &decoded[i]
+	}
+	for i, off := range info.Offsets() {
+		b := block{Index: i, Offset: off}
+		if fix, ok := fixes[off]; ok && off >= 0 {
+			raw := make([]byte, size)
+			if _, err := movFile.ReadAt(raw, off); err != nil {
+				return nil, err
+			}
+			b.Emitted, b.Raw = written[off], hex.EncodeToString(raw)
+			b.Point = &point{Time: fix.Time, Lat: fix.Lat, Lon: fix.Lon,
+				Source: "binary fields",
+				Flags: integrity.ByBlock[off].String()}
+			if fix.FromRMC {
+				b.Point.Source = "RMC sentence"
+			}
+			if fix.RMC != nil {
+				b.Point.RMC = fix.RMC.Fields
+			}
+			if fix.GGA != nil {
+				b.Point.GGA = fix.GGA.Fields
+			}
+		}
+		r.Blocks = append(r.Blocks, b)
+	}
+	//}}}
+	//{{{  points written which are not a fix as decoded
+	for i := range points {
+		p := &points[i]
+		if source := provenance(p); source != "" {
+			r.Processed = append(r.Processed, processed{Time: p.Time,
+				Lat: p.Lat, Lon: p.Lon, Source: source, Block: p.Offset})
+		}
+	}
+	//}}}
+	return r, nil
+}
+//}}}
+//{{{  writeReport(base,r) -- base.report.json and base.report.txt
+func writeReport(base string, r *evidence) error {
+	jsonPath, textPath := base+".report.json", base+".report.txt"
+	//{{{  Check for overwrite
+	if !*overwrite {
+		for _, p := range []string{jsonPath, textPath} {
+			if _, err := os.Stat(p); err == nil {
+				return errors.New(fmt.Sprintf("%s: already exists. Use -w to overwrite.", p))
+			}
+		}
+	}
+	//}}}
+	//{{{  JSON manifest
+	j, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(jsonPath, append(j, '\n'), 0644); err != nil {
+		return err
+	}
+	//}}}
+	textFile, err := os.Create(textPath)
+	if err != nil {
+		return err
+	}
+	defer textFile.Close()
+	w := bufio.NewWriter(textFile)
+	writeSummary(w, r)
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	if *verbose {
+		fmt.Fprintf(os.Stderr, "\tReport: %s, %s\n", jsonPath, textPath)
+	}
+	return nil
+}
+//}}}
+//{{{  writeSummary(w,r) -- the human readable report
+func writeSummary(w io.Writer, r *evidence) {
+	in := r.Input
+	fmt.Fprintf(w, "GPS evidence report: %s %s (%s), %s\n\n", r.Tool,
+		r.Version, r.GoVersion, r.Generated.Format(time.RFC3339))
+	fmt.Fprintf(w, "Input       %s\n", in.Path)
+	fmt.Fprintf(w, "  size      %d bytes\n", in.Size)
+	fmt.Fprintf(w, "  SHA-256   %s\n", in.SHA256)
+	fmt.Fprintf(w, "  comment   %s\n", in.Comment)
+	fmt.Fprintf(w, "  firmware  %s\n", in.Firmware)
+	if in.Created != nil {
+		fmt.Fprintf(w, "  created   %s (camera clock)\n",
+			in.Created.Format("2006-01-02 15:04:05"))
+	}
+	if in.Duration > 0 {
+		fmt.Fprintf(w, "  duration  %.3fs\n", in.Duration)
+	}
+	if in.MovieError != "" {
+		fmt.Fprintf(w, "  mvhd      unreadable: %s\n", in.MovieError)
+	}
+	fmt.Fprintf(w, "\nDecoder     %s\n", r.Decoder.Layout)
+	fmt.Fprintf(w, "  blocks    %s, %d bytes read each\n",
+		r.Decoder.Method, r.Decoder.BlockSize)
+	fmt.Fprintf(w, "  options   %v\n", r.Decoder.Options)
+	fmt.Fprintf(w, "\nOutput      %s\n", r.Output.Path)
+	fmt.Fprintf(w, "  SHA-256   %s\n", r.Output.SHA256)
+	fmt.Fprintf(w, "  points    %d, %d not as decoded, from %d blocks\n",
+		r.Output.Points, len(r.Processed), len(r.Blocks))
+	fmt.Fprintf(w, "  integrity %s\n", r.Integrity)
+	//{{{  One line per block
+	fmt.Fprintf(w, "\n%6s %12s  %-24s %11s %11s  %s\n",
+		"block", "offset", "time (UTC)", "lat", "lon", "source")
+	for _, b := range r.Blocks {
+		switch {
+		case b.Offset < 0:
+			fmt.Fprintf(w, "%6d %12s  missing\n", b.Index, "-")
+		case b.Point == nil:
+			fmt.Fprintf(w, "%6d %#12x  no fix\n", b.Index, b.Offset)
+		default:
+			p := b.Point
+			source := p.Source
+			if p.Flags != "" {
+				source += "  [" + p.Flags + "]"
+			}
+			if !b.Emitted {
+				source += "  not in gpx"
+			}
+			fmt.Fprintf(w, "%6d %#12x  %-24s %11.6f %11.6f  %s\n",
+				b.Index, b.Offset,
+				p.Time.Format("2006-01-02T15:04:05.999Z"),
+				p.Lat, p.Lon, source)
+		}
+	}
+	//}}}
+	//{{{  Then the points not as decoded
+	if len(r.Processed) > 0 {
+		fmt.Fprintf(w, "\nPoints in the gpx not as decoded\n")
+		fmt.Fprintf(w, "%19s  %-24s %11s %11s  %s\n",
+			"block", "time (UTC)", "lat", "lon", "source")
+		for _, p := range r.Processed {
+			block := "-"
+			if p.Block >= 0 {
+				block = fmt.Sprintf("%#x", p.Block)
+			}
+			fmt.Fprintf(w, "%19s  %-24s %11.6f %11.6f  %s\n", block,
+				p.Time.Format("2006-01-02T15:04:05.999Z"),
+				p.Lat, p.Lon, p.Source)
+		}
+	}
+	//}}}
+	fmt.Fprintf(w, "\nRaw blocks behind each point are in the JSON manifest.\n")
+}
+//}}}
@@ -0,0 +1,210 @@
+//{{{  license
+// Copyright 2018,2019 A E Lawrence
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//}}}
+
+package main
+
+//{{{  imports
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/clarified/mov2gps/go/nb"
+	"github.com/clarified/mov2gps/go/track"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+//}}}
+
+//{{{  Trip statistics -- overview
+// With -stats no gpx is written. Instead each MOV file is summarised on
+// stdout, and then the trip: all the files merged in time order, as a
+// dashcam records a drive as a run of short clips. Local times are in the
+// zone the camera was set to, when the GPS blocks show it, else in the
+// zone of this computer. Only the fixes as decoded and cleaned count: the
+// track stages would add distance and time nobody measured.
+//}}}
+
+//{{{  summary types -- the JSON, and what the text is written from
+type summary struct {
+	Path		string		`json:"path,omitempty"`
+	Files		int		`json:"files,omitempty"`
+	Points		int		`json:"points"`
+	Start		*when		`json:"start,omitempty"`
+	End		*when		`json:"end,omitempty"`
+	Distance	float64		`json:"distance_km"`
+	Moving		float64		`json:"moving_seconds"`
+	Stopped		float64		`json:"stopped_seconds"`
+	AvgSpeed	float64		`json:"average_speed_kmh"`
+	MaxSpeed	float64		`json:"max_speed_kmh"`
+	Gain		*float64	`json:"elevation_gain_m,omitempty"`
+	Loss		*float64	`json:"elevation_loss_m,omitempty"`
+}
+
+type when struct {
+	UTC		time.Time	`json:"utc"`
+	Local		string		`json:"local"`
+}
+
+type tripStats struct {
+	Files		[]summary	`json:"files"`
+	Trip		summary		`json:"trip"`
+}
+//}}}
+
+//{{{  newSummary(s,loc) -- track.Stats in the units people use
+func newSummary(s track.Stats, loc *time.Location) summary {
+	sum := summary{
+		Points:		s.Points,
+		Distance:	s.Distance / 1000,
+		Moving:		s.Moving.Seconds(),
+		Stopped:	s.Stopped.Seconds(),
+		AvgSpeed:	s.AvgSpeed * 3.6,
+		MaxSpeed:	s.MaxSpeed * 3.6,
+	}
+	if s.Points > 0 {
+		sum.Start = &when{UTC: s.Start, Local: s.Start.In(loc).Format(time.RFC3339)}
+		sum.End = &when{UTC: s.End, Local: s.End.In(loc).Format(time.RFC3339)}
+	}
+	if s.Elevation {
+		sum.Gain, sum.Loss = &s.Gain, &s.Loss
+	}
+	return sum
+}
+//}}}
+//{{{  fileFixes(movPath) -- the fixes in a file, and the camera's zone
+// loc is nil if the zone can't be told.
+func fileFixes(movPath string) ([]nb.Fix, *time.Location, error) {
+	if !strings.EqualFold(filepath.Ext(movPath), ".mov") {
+		return nil, nil, errors.New(fmt.Sprintf("%v: Does not end with .MOV", movPath))
+	}
+	movFile, err := os.Open(movPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer movFile.Close()
+	var info nb.GPSInfo
+	switch {
+	case *recovery	: info = nb.NewRecoveryInfo(movFile)
+	default		: info = nb.NewInfo(movFile)
+	}
+	gpsLogs, _, err := info.GPSLogs()
+	if err != nil {
+		return nil, nil, err
+	}
+	if *verbose || *debug {
+		fmt.Fprintf(os.Stderr, "%s\n", movPath)
+	}
+	var loc *time.Location
+	// Only the offset is wanted, not whether it is summer time.
+	if zone, ok := nb.InferZone(gpsLogs, nil); ok {
+		loc = zone.Location()
+	}
+	fixes, err := cleanFixes(info)
+	return fixes, loc, err
+}
+//}}}
+//{{{  statistics(movPaths) -- -stats: each file, then the trip
+func statistics(movPaths []string) error {
+	var r tripStats
+	var all []nb.Fix
+	var tripLoc *time.Location
+	for _, movPath := range movPaths {
+		fixes, loc, err := fileFixes(movPath)
+		if err != nil {
+			return err
+		}
+		if tripLoc == nil {
+			tripLoc = loc
+		}
+		if loc == nil {
+			loc = time.Local
+		}
+		sum := newSummary(track.Summarise(fixes), loc)
+		sum.Path = movPath
+		r.Files = append(r.Files, sum)
+		all = append(all, fixes...)
+	}
+	//{{{  The trip: all the files in time order
+	if tripLoc == nil {
+		tripLoc = time.Local
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].Time.Before(all[j].Time)
+	})
+	//{{{  Fixes in more than one file count once
+	// Clips given twice, or which overlap, repeat GPS times.
+	once := all[:0]
+	for i := range all {
+		if len(once) == 0 || !all[i].Time.Equal(once[len(once)-1].Time) {
+			once = append(once, all[i])
+		}
+	}
+	all = once
+	//}}}
+	r.Trip = newSummary(track.Summarise(all), tripLoc)
+	r.Trip.Files = len(movPaths)
+	//}}}
+	if *stats == "json" {
+		j, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(append(j, '\n'))
+		return err
+	}
+	for _, sum := range r.Files {
+		writeStats(os.Stdout, sum.Path, sum)
+	}
+	if len(r.Files) > 1 {
+		writeStats(os.Stdout, fmt.Sprintf("Trip: %d files", r.Trip.Files), r.Trip)
+	}
+	return nil
+}
+//}}}
+//{{{  writeStats(w,title,sum) -- one summary as text
+func writeStats(w io.Writer, title string, sum summary) {
+	fmt.Fprintf(w, "%s\n", title)
+	if sum.Points == 0 {
+		fmt.Fprintf(w, "  no fixes\n\n")
+		return
+	}
+	for _, t := range []struct {
+		name	string
+		at	*when
+	}{{"start", sum.Start}, {"end", sum.End}} {
+		local, _ := time.Parse(time.RFC3339, t.at.Local)
+		fmt.Fprintf(w, "  %-10s %s UTC, %s local (%s)\n", t.name,
+			t.at.UTC.Format("2006-01-02 15:04:05"),
+			local.Format("2006-01-02 15:04:05"), local.Format("-07:00"))
+	}
+	second := func(s float64) time.Duration {
+		return time.Duration(s * float64(time.Second)).Round(time.Second)
+	}
+	fmt.Fprintf(w, "  %-10s %.3f km\n", "distance", sum.Distance)
+	fmt.Fprintf(w, "  %-10s %v moving, %v stopped\n", "time",
+		second(sum.Moving), second(sum.Stopped))
+	fmt.Fprintf(w, "  %-10s %.1f km/h average while moving, %.1f km/h max\n",
+		"speed", sum.AvgSpeed, sum.MaxSpeed)
+	if sum.Gain != nil {
+		fmt.Fprintf(w, "  %-10s +%.0f m, -%.0f m\n", "elevation", *sum.Gain, *sum.Loss)
+	}
+	fmt.Fprintf(w, "  %-10s %d\n\n", "points", sum.Points)
+}
+//}}}
@@ -72,14 +72,14 @@ type GPSLog struct {
 	// Can test by examining MagicRMC
 	// Data beyond this point only seen in some firmware versions
 	_		[12]byte	// Unknown
-	// Next 14 bytes might a recent displayed date/ (summmer)time
-	// Seems to lag by 2 or 2 or 3 seconds??
-	_		[4]byte		// Ascii year
-	_		[2]byte		// Ascii month 
-	_		[2]byte		// Ascii day digits
-	_		[2]byte		// Ascii digits hour adjusted for summer??
-	_		[2]byte		// Ascii digits min ?
-	_		[2]byte		// Ascii digits. Secs @ end of last file?
+	// Next 14 bytes seem to be the displayed local date/ (summmer)time
+	// Seems to lag by 2 or 2 or 3 seconds?? See Method Local.
+	LocalYear	[4]byte		// Ascii year
+	LocalMon	[2]byte		// Ascii month 
+	LocalDay	[2]byte		// Ascii day digits
+	LocalHour	[2]byte		// Ascii digits hour adjusted for summer
+	LocalMin	[2]byte		// Ascii digits min
+	LocalSec	[2]byte		// Ascii digits. Secs @ end of last file?
 	_		[14]byte	// Unknown - zeroed in latest firmware?
 	// Perhaps there may be variable width fields below here
 	// as there are in $GPGGA, in which case we need to
@@ -163,6 +163,10 @@ type GPSLog struct {
 //{{{  GPSInfo interface -- method to extract GPSLogs
 type GPSInfo interface {
 	GPSLogs() ([]GPSLog,*UserData,error)
+	Fixes() ([]Fix,*UserData,error)
+	Missing() []int
+	Offsets() []int64
+	Scanned() bool
 }
 //}}}
 //{{{  type UserData - used to pass comment & format
@@ -178,14 +182,30 @@ type genRead interface {
 }
 //}}}
 //{{{  gpsRas struct -- Seems difficult to convert to simple alias
+// The rest is filled in by the first call of GPSLogs: offsets holds the
+// file offset of each GPS block read, -1 if missing. Later calls reuse
+// the results. scanned is set to find the blocks by reading the whole
+// file: see ScanLogs.
 type gpsRas struct {
-	ras genRead
+	ras	genRead
+	sound	*mov.Track
+	offsets	[]int64
+	logs	[]GPSLog
+	udata	*UserData
+	scanned	bool
 }
 //}}}
 //{{{  NewInfo -- just builds a gpsRas struct around a "ras"
 // ras is called with a type from os.Open, thus *os.File 
 func NewInfo(ras genRead) GPSInfo {
-	return &gpsRas{ras}
+	return &gpsRas{ras: ras}
+}
+//}}}
+//{{{  NewRecoveryInfo -- ignore the sample tables and scan for the blocks
+// For files where moov is missing or can't be trusted. NewInfo falls back
+// on this anyway when there are no sound chunks, or they can't be read.
+func NewRecoveryInfo(ras genRead) GPSInfo {
+	return &gpsRas{ras: ras, scanned: true}
 }
 
 //}}}
@@ -194,22 +214,72 @@ func NewInfo(ras genRead) GPSInfo {
 // Since UserData so small, pointer not really needed, but good practice.
 func (sgi *gpsRas) GPSLogs() ([]GPSLog,*UserData, error) {
 
+	if sgi.logs != nil {
+		return sgi.logs, sgi.udata, nil
+	}
 	var udata UserData
-	sa := &sampleAccumulator{}
-	if err := mov.VisitAtoms(sa, sgi.ras); err != nil {
+	var audioOffsets []uint64
+	var gpsLogs []GPSLog
+	var err error
+	if !sgi.scanned {
+		sgi.sound, audioOffsets, err = soundChunks(sgi.ras)
+		// Above sets position of sgi.ras to end.
+		// No sound chunks: most likely no moov, after a power cut.
+		// A moov that can't be read is no better: scan instead.
+		if err != nil && debug {
+			log.Printf("sound chunks: %v: scanning\n", err)
+		}
+		sgi.scanned = err != nil || len(audioOffsets) == 0
+	}
+	if sgi.scanned {
+		sgi.sound = nil
+		gpsLogs, err = sgi.scanLogs()
+	} else {
+		gpsLogs, err = sgi.readBlocks(audioOffsets)
+	}
+	if err != nil {
 		return nil,nil, err
 	}
-	// Above sets position of sgi.ras to end.
-	gpsLogs := make([]GPSLog, len(sa.audioOffsets))
+	// The udta strings are only descriptive: if moov can't be read for
+	// them, the blocks found are still good, and the strings are left empty.
+	if udata.Inf, err = userString(sgi.ras, fmtSelector); err != nil {
+		log.Printf("udta: %v\n", err)
+		udata.Inf = nil
+	}
+	if udata.Fmt, err = userString(sgi.ras, infSelector); err != nil {
+		log.Printf("udta: %v\n", err)
+		udata.Fmt = nil
+	}
+	sgi.logs, sgi.udata = gpsLogs, &udata
+	return gpsLogs,&udata,nil
+	//{{{  Original returned a copy of the slice
+	//return gpsLogs[:],udata,nil  -- unclear why the original version
+	//	returned a copy. No measured change to performance.
+	//	We are already returning a slice, so essentially by reference
+	//	so no redundant copying of the underlying array. Not clear
+	//	what just copying the (pointer,len,cap) achieved here.
+	//	Perhaps missing something?
+	//}}}
+
+}
+//}}}
+
+//{{{  Method readBlocks(audioOffsets) -- the GPSLog after each sound chunk
+func (sgi *gpsRas) readBlocks(audioOffsets []uint64) ([]GPSLog, error) {
+	var err error
+	gpsLogs := make([]GPSLog, len(audioOffsets))
+	if sgi.offsets, err = sgi.findBlocks(audioOffsets); err != nil {
+		return nil, err
+	}
 
-	//{{{  Read the gps atom 64k bytes following each offset
-	// and fail if it doesn't exist.
-	// These atoms in the mdat are  of type "free", but we
-	// don't match that.
+	//{{{  Read the gps atom found after each sound chunk
+	// These atoms in the mdat are  of type "free", usually 64k bytes after
+	// the sound chunk offset: see findBlocks.
 	// With early firmware, they are 32K long, but with later version
 	// they have grown to 64K. At most the  first 336 bytes contains non-zero
 	// characters: the GPS info.
 	// Maybe writing to 32/64K blocks helps with writing flash quickly?
+	// Missing blocks are left zeroed, so Rubbish, and can't be decoded.
 
 	//{{{  Stategy: read the GPS data directly 
 	// The original code reused mov.VisitAtoms, but that required
@@ -222,31 +292,26 @@ func (sgi *gpsRas) GPSLogs() ([]GPSLog,*UserData, error) {
 	// go, which is what we now do.
 	//}}}
 
-	for i, offset := range sa.audioOffsets {
-		goff := int64(offset + 0x10000)	
+	for i, goff := range sgi.offsets {
+		if goff < 0 {
+			continue
+		}
 		_,err := sgi.ras.Seek(goff,io.SeekStart)
 		if err != nil {
-			return nil,nil,err
+			return nil,err
 		}
-		if err = binary.Read(sgi.ras, binary.LittleEndian, &gpsLogs[i]); 
-			err != nil {
-				return nil,nil, err
+		err = binary.Read(sgi.ras, binary.LittleEndian, &gpsLogs[i])
+		switch err {
+		case nil:
+		case io.ErrUnexpectedEOF:	// block cut short by end of file
+			gpsLogs[i], sgi.offsets[i] = GPSLog{}, -1
+		default:
+			return nil, err
 		}
 
 	}
 	//}}}
-	udata.Inf = sa.format
-	udata.Fmt = sa.comment
-	return gpsLogs,&udata,nil
-	//{{{  Original returned a copy of the slice
-	//return gpsLogs[:],udata,nil  -- unclear why the original version
-	//	returned a copy. No measured change to performance.
-	//	We are already returning a slice, so essentially by reference
-	//	so no redundant copying of the underlying array. Not clear
-	//	what just copying the (pointer,len,cap) achieved here.
-	//	Perhaps missing something?
-	//}}}
-
+	return gpsLogs, nil
 }
 //}}}
 
@@ -291,15 +356,15 @@ func getUserData (sr *io.SectionReader) ([]byte,error) {
 	return target,nil
 }
 //}}}
-//{{{  sampleAccumulator struct
-type sampleAccumulator struct {
-	inSound		bool
-	audioOffsets	[]uint32
-	format		[]byte
-	comment		[]byte
-}
-//}}}
-//{{{  Method Visit for *sampleAccumulator
+//{{{  Selectors for the atoms we need
+// The Nextbase udta entries are a bit dirty, so care needed. Trailing garbage
+// after counted string in one case. Many trailing zeros included in the
+// counted string in the other case.
+//   '@fmt -> Nextbase, @inf (old :just model, new: firmware version)
+const (
+	fmtSelector	= "moov/udta/\xa9fmt"
+	infSelector	= "moov/udta/\xa9inf"
+)
 //{{{  frea atom - seems to be a Kodak special
 // note parked here for now: frea atom
 // Nextbase seem to use a Kodak special frea atom matching
@@ -313,115 +378,37 @@ type sampleAccumulator struct {
 //'ver ' 	KodakVersion 	no 	 
 //}}}
 //}}}
-
-// const  copyright = 0xa9	// or just \xa9 - for udta atoms 
-
-func (sa *sampleAccumulator) Visit(path []string, sr *io.SectionReader) error {
-	last := len(path) - 1
-	//{{{  Ensure that the path can be split. Otherwise not a target.
-	if last < 1 {
-		return nil
+//}}}
+//{{{  soundChunks(ras) -- first sound track and its chunk offsets
+// The sound track is identified by its handler. There may be more than
+// one: the first is used.
+// No sound track is not an error: there are just no offsets.
+func soundChunks(ras mov.ReadAtSeeker) (*mov.Track, []uint64, error) {
+	tracks, err := mov.Tracks(ras)
+	if err != nil {
+		return nil, nil, err
 	}
-	//}}}
-	cur := path[last]
-	switch {
-	//{{{  Note when in sound track
-	// the inside minf test may be redundant
-	case  cur == "smhd" && inside(path[:last],"minf"):
-		sa.inSound = true
-		return nil
-	//}}} 
-	//{{{  record when exit from sound
-	// Ought to make this more robust? Best would be when exit track, but
-	// not so easy with this approach. Instead whenever enter a track?
-	//case len(path) < 5 || cur == "vmhd":
-	case  cur == "trak":
-		sa.inSound = false
-		return nil
-	//}}}
-	//{{{  Get the offsets when encounter the sound "stco"
-	// Make this more robust as well....
-	case sa.inSound && cur == "stco" && inside(path[:last], "stbl") :
-		offsets, err := getAudioChunks(sr)
-		sa.audioOffsets = offsets
-		return err
-	//}}}
-	//{{{  format info 
-	// Assume only one such udta atom is present, else we will
-	// overwrite and only return the last.
-	// The Nextbase entries are a bit dirty, so care needed. Trailing garbage
-	// after counted string in one case. Many trailing zeros included in the
-	// counted string in the other case.
-	case cur == "\xa9fmt" && path[last-1] == "udta" :
-		// need to set sa.Format from nil to value
-		s,_ := getUserData(sr)
-		sa.format = TrimTrailingZeros(s)
-		return nil
-	//}}}
-	//{{{  Comment information
-	case cur == "\xa9inf" && path[last-1] == "udta" :
-		s,_ := getUserData(sr)
-		sa.comment = TrimTrailingZeros(s)
-		return nil
-	//}}}
-	default:
-		return nil
+	sound := mov.FirstTrack(tracks, "soun")
+	if sound == nil {
+		return nil, nil, nil
 	}
-}
-//}}}
-//{{{  inside(path,target) : is target in path?
-// Used to check for a target string in the path, looking from the end
-// rather than the start. Used in parsing the atom heirarchy.
-func inside(p []string, target string) bool {
-	if  end := len(p) -1 ; end < 0 {
-		return false		//empty p
-	} else {
-		//{{{  Look for immediate match, recurse otherwise
-		if match := p[end] == target ; match {
-			//{{{  Return true  immediately if a match
-			return match
-			//}}}
-		} else {
-			//{{{   Recurse if there is more
-			switch {
-				case end > 0 : return inside(p[:end - 1],target)
-				default	     : return false
-			}
-			//}}}
-		}
-		//}}}
+	offsets, err := sound.ChunkOffsets()
+	if debug {
+		log.Printf("chunk offsets: %x\n", offsets)
 	}
+	return sound, offsets, err
 }
 //}}}
-//{{{  getAudioChunks from stco atom
-// Examine stco atom
-func getAudioChunks(sr *io.SectionReader) ([]uint32, error) {
-	//{{{  discard version/flags
-	_,err := sr.Seek(4,io.SeekCurrent)
-	if err != nil {
+//{{{  userString(ras,selector) -- trimmed udta string, if any
+// Assume only one such udta atom is present, else we only return the first.
+// Errors in the string itself are ignored as before: the strings are only
+// informative.
+func userString(ras mov.ReadAtSeeker, selector string) ([]byte, error) {
+	found, err := mov.Find(ras, selector)
+	if err != nil || len(found) == 0 {
 		return nil, err
 	}
-	//}}}
-	var nent uint32
-	//{{{  collect number of entries
-	err = binary.Read(sr, binary.BigEndian, &nent)
-	if err != nil {
-		return nil, err
-	}
-	//}}}
-	result := make([]uint32, nent)
-
-	//{{{  Read chunk offset table
-	for i := range result {
-		err = binary.Read(sr, binary.BigEndian, &result[i])
-		if err != nil {
-			return nil, err
-		}
-	}
-	//}}}
-	if debug {
-		log.Printf("chunk offsets: %x\n", result)
-	}
-	return result, nil
+	s, _ := getUserData(found[0])
+	return TrimTrailingZeros(s), nil
 }
 //}}}
@@ -0,0 +1,491 @@
+//{{{  License
+// Copyright 2016 KB Sriram
+// Copyright 2018,2019 A E Lawrence
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//}}}
+
+package nb
+
+//{{{  imports
+import (
+	"errors"
+	"github.com/clarified/mov2gps/go/nmea"
+	"log"
+	"math"
+	"strings"
+	"time"
+)
+//}}}
+
+var ErrNotCovered = errors.New("Time not covered by the GPS track")
+
+//{{{  type Fix -- a decoded GPSLog, placed in the clip
+// Fix is a GPSLog decoded into ordinary units and placed on the clip
+// timeline. Media is the playback time of the sound chunk which the GPS
+// block follows, so it is the time into the video, not the wall clock.
+// Offset is the position of the GPS block in the MOV file.
+// Constellation is from the NMEA talker, "" if there are no sentences.
+// RMC and GGA are the checksum-valid sentences, nil if absent or corrupt:
+// writers may want their text as well as the values. FromRMC is set when
+// time and position had to be rebuilt from the RMC sentence. Precise is
+// set when Time carries a fraction of a second written by the receiver,
+// rather than one estimated by Fixes. Synthetic is set on points made up
+// to fill a gap between fixes, rather than decoded from a block. Repaired
+// is set when an outlying position was replaced by one between the fixes
+// either side; the sentences, which gave the old one, are dropped.
+// Interpolated is set on points put between two fixes, as by resampling:
+// they have no block, so Offset is -1, nor sentences. Smoothed is set when
+// the position was estimated from the whole track rather than taken as
+// given; the sentences are those of the fix as given.
+type Fix struct {
+	Time		time.Time	// UTC
+	Lat		float64		// decimal degrees, WGS84
+	Lon		float64
+	Speed		float64		// metres/second
+	Course		float64		// degrees true
+	Status		byte		// A - valid, V - warning, 0 unknown
+	Media		time.Duration
+	Offset		int64
+	Constellation	string
+	RMC		*nmea.RMC
+	GGA		*nmea.GGA
+	FromRMC		bool
+	Precise		bool
+	Synthetic	bool
+	Repaired	bool
+	Interpolated	bool
+	Smoothed	bool
+}
+//}}}
+//{{{  type Pass -- a visit to a place during a clip
+// Pass is a run of consecutive fixes within some radius of a place.
+// Closest is the fix nearest the place, Distance how near it came (metres).
+type Pass struct {
+	Enter		time.Duration
+	Leave		time.Duration
+	Closest		Fix
+	Distance	float64
+}
+//}}}
+
+const knotsToMpersec = 1852.0/3600.0	//Decimal to get float64
+const earthRadius = 6371008.8		// mean radius, metres
+
+//{{{  ToDD(spec,v) float64
+// Input comes as decimal minutes
+func ToDD(spec byte, v float32) float64 {
+	deg, frac := math.Modf(float64(v) / 100)
+	result := deg + frac/0.6
+	if spec == 'S' || spec == 'W' {
+		result = -result
+	}
+	return result
+}
+//}}}
+//{{{  Method Rubbish(clean) -- should this block be ignored?
+// Real gps videos always seem to have "GPS ", even when rubbish points.
+// Testing for GPS is worthwhile when fed non gps video
+// With clean set, points at lat/long 0/0 are rubbish too.
+func (g *GPSLog) Rubbish(clean bool) bool {
+	return string(g.Magic[:]) != "GPS " ||
+		(clean && g.Latitude == 0 && g.Longitude == 0) ||
+		g.Mon == 0
+}
+//}}}
+//{{{  Method UTC -- time of the fix from the binary fields
+func (g *GPSLog) UTC() time.Time {
+	return time.Date(2000+int(g.Year), time.Month(g.Mon), int(g.Day),
+		int(g.Hour), int(g.Min), int(g.Sec), 0, time.UTC)
+}
+//}}}
+//{{{  Method binaryTime -- UTC, ok false if missing or nonsense
+// time.Date normalises nonsense like month 13, so check nothing moved.
+func (g *GPSLog) binaryTime() (time.Time, bool) {
+	if g.Mon == 0 {
+		return time.Time{}, false
+	}
+	t := g.UTC()
+	return t, int(g.Mon) == int(t.Month()) && int(g.Day) == t.Day() &&
+		int(g.Hour) == t.Hour() && int(g.Min) == t.Minute() &&
+		int(g.Sec) == t.Second()
+}
+//}}}
+//{{{  Method binaryPosition -- lat,lon, ok false if missing or nonsense
+// 0/0 counts as missing here, since that is what the cameras write
+// before a fix.
+func (g *GPSLog) binaryPosition() (lat, lon float64, ok bool) {
+	lat = ToDD(g.LatitudeSpec, g.Latitude)
+	lon = ToDD(g.LongitudeSpec, g.Longitude)
+	ok = !(g.Latitude == 0 && g.Longitude == 0) &&
+		math.Abs(lat) <= 90 && math.Abs(lon) <= 180 &&
+		(g.LatitudeSpec == 'N' || g.LatitudeSpec == 'S') &&
+		(g.LongitudeSpec == 'E' || g.LongitudeSpec == 'W')
+	return
+}
+//}}}
+//{{{  RMC fallback -- overview
+// Some firmware zeroes the binary date or position but still writes a
+// good $GPRMC sentence, and the binary fields are not protected by any
+// checksum while the sentence is. So:
+//   - time comes from the binary fields, unless they are missing or
+//     disagree with a checksum-valid RMC by more than a second;
+//   - when the binary second matches RMC, or failing that GGA, the
+//     fraction from the sentence is added, for receivers faster than 1 Hz;
+//   - position comes from the binary fields, unless they are missing or
+//     more than rmcTolerance metres from an RMC position;
+//   - speed and course follow the position.
+//}}}
+const rmcTolerance = 50.0	// metres
+
+//{{{  Decode(g) -- Fix from g, falling back on RMC
+// Media and Offset are left for the caller. ok is false if g is not a GPS
+// block, or has no time from either source. A fix with no position from
+// either source is returned at 0/0: see Clean.
+func Decode(g *GPSLog) (fix Fix, ok bool) {
+	if string(g.Magic[:]) != "GPS " {
+		return fix, false
+	}
+	//{{{  Collect the checksum-valid sentences
+	var err error
+	if fix.RMC, err = g.RMC(); err != nil {
+		fix.RMC = nil
+		if debug && g.HasRMC() {
+			log.Printf("RMC rejected: %q (%v)\n", g.RMCentries, err)
+		}
+	}
+	if fix.GGA, err = g.GGA(); err != nil {
+		fix.GGA = nil
+		if debug && g.HasGGA() {
+			log.Printf("GGA rejected: %q (%v)\n", g.GGAentries, err)
+		}
+	}
+	switch {
+	case fix.GGA != nil	: fix.Constellation = nmea.Constellation(fix.GGA.Talker)
+	case fix.RMC != nil	: fix.Constellation = nmea.Constellation(fix.RMC.Talker)
+	}
+	rmc := fix.RMC
+	//}}}
+	//{{{  Time
+	bt, bok := g.binaryTime()
+	var rt time.Time
+	rok := rmc != nil && rmc.Valid(nmea.RMCTime) && rmc.Valid(nmea.RMCDate)
+	if rok {
+		rt = rmc.Date.Add(rmc.Time)
+	}
+	switch {
+	case bok && rok && rt.Truncate(time.Second).Equal(bt):
+		fix.Time, fix.Precise = rt, fractional(rmc.Field(nmea.RMCTime))
+	case rok && (!bok || rt.Sub(bt) > time.Second || bt.Sub(rt) > time.Second):
+		fix.Time, fix.FromRMC = rt, true
+		fix.Precise = fractional(rmc.Field(nmea.RMCTime))
+	case bok:
+		fix.Time = bt
+	default:
+		return fix, false
+	}
+	//{{{  GGA has no date, but may have the fraction when RMC doesn't
+	if gga := fix.GGA; !fix.Precise && gga != nil && gga.Valid(nmea.GGATime) &&
+		fractional(gga.Field(nmea.GGATime)) {
+		gt := fix.Time.Truncate(24 * time.Hour).Add(gga.Time)
+		if gt.Truncate(time.Second).Equal(fix.Time.Truncate(time.Second)) {
+			fix.Time, fix.Precise = gt, true
+		}
+	}
+	//}}}
+	//}}}
+	//{{{  Position, speed and course
+	lat, lon, pok := g.binaryPosition()
+	rpok := rmc != nil && rmc.Valid(nmea.RMCLat) && rmc.Valid(nmea.RMCLon)
+	if rpok && (!pok || Distance(lat, lon, rmc.Lat, rmc.Lon) > rmcTolerance) {
+		fix.Lat, fix.Lon, fix.FromRMC = rmc.Lat, rmc.Lon, true
+		fix.Speed = rmc.Speed * knotsToMpersec
+		fix.Course = rmc.Course
+	} else {
+		fix.Lat, fix.Lon = lat, lon
+		if !pok {
+			fix.Lat, fix.Lon = 0, 0
+		}
+		fix.Speed = float64(g.Speed) * knotsToMpersec
+		fix.Course = float64(g.Course)
+	}
+	//}}}
+	//{{{  Status: binary if it says, else RMC
+	switch {
+	case g.ReceiverSpec == 'A' || g.ReceiverSpec == 'V':
+		fix.Status = g.ReceiverSpec
+	case rmc != nil:
+		fix.Status = rmc.Status
+	}
+	//}}}
+	return fix, true
+}
+//}}}
+//{{{  Method FixType -- GPX fix: none, 2d, 3d, dgps or pps
+// From the receiver status, then the GGA quality. GGA quality 1 is just
+// "GPS", neither 2d nor 3d, so the dimension is told from the fix itself:
+// an altitude from 4 or more satellites is 3d, 3 satellites only give 2d.
+// "" if none of that says.
+func (f *Fix) FixType() string {
+	if f.Status == 'V' {
+		return "none"
+	}
+	g := f.GGA
+	if g == nil || !g.Valid(nmea.GGAQuality) {
+		return ""
+	}
+	switch g.Quality {
+	case 0		: return "none"
+	case 2, 4, 5	: return "dgps"	// differential, RTK
+	case 3		: return "pps"
+	case 1:
+		switch {
+		case !g.Valid(nmea.GGASats)	: return ""
+		case g.Sats == 3		: return "2d"
+		case g.Sats >= 4 && g.Valid(nmea.GGAAltitude): return "3d"
+		}
+	}
+	return ""
+}
+//}}}
+//{{{  Method HDOP -- from GGA; ok false if it hasn't one
+func (f *Fix) HDOP() (float64, bool) {
+	if f.GGA != nil && f.GGA.Valid(nmea.GGAHDOP) {
+		return f.GGA.HDOP, true
+	}
+	return 0, false
+}
+//}}}
+//{{{  fractional(f) -- does an NMEA time field have a fraction?
+// hhmmss.000 counts: the receiver is saying the fix is on the second.
+func fractional(f string) bool {
+	return strings.IndexByte(f, '.') >= 0
+}
+//}}}
+//{{{  Clean(fixes) -- drop the points at 0/0
+// Cameras write 0/0 before they have a fix, or when it is lost.
+func Clean(fixes []Fix) []Fix {
+	kept := fixes[:0:0]
+	for _, fix := range fixes {
+		if fix.Lat != 0 || fix.Lon != 0 {
+			kept = append(kept, fix)
+		}
+	}
+	return kept
+}
+//}}}
+//{{{  Distance(lat1,lon1,lat2,lon2) -- great circle metres
+// Haversine on a sphere of the mean earth radius. Good to a fraction of
+// a percent, which is far better than the fixes.
+func Distance(lat1, lon1, lat2, lon2 float64) float64 {
+	const rad = math.Pi / 180
+	dlat := (lat2 - lat1) * rad
+	dlon := (lon2 - lon1) * rad
+	a := math.Sin(dlat/2)*math.Sin(dlat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*
+			math.Sin(dlon/2)*math.Sin(dlon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+//}}}
+
+//{{{  Method chunkTimes(n) -- media time of the first n sound chunks
+// The time of a chunk is the decode time of its first sample.
+// ok is false if the sample tables can't be resolved.
+func (sgi *gpsRas) chunkTimes(n int) (times []time.Duration, ok bool) {
+	if sgi.sound == nil || sgi.sound.Timescale == 0 {
+		return nil, false
+	}
+	chunks, err := sgi.sound.Chunks()
+	if err != nil || len(chunks) < n {
+		if debug {
+			log.Printf("sound chunks: %d, %v\n", len(chunks), err)
+		}
+		return nil, false
+	}
+	times = make([]time.Duration, n)
+	for i := range times {
+		times[i] = time.Duration(float64(chunks[i].DecodeTime) /
+			float64(sgi.sound.Timescale) * float64(time.Second))
+	}
+	return times, true
+}
+//}}}
+//{{{ Method Fixes - decoded GPSLogs, placed in the clip
+// Fixes decodes the GPSLogs, dropping blocks which can't be decoded.
+// Points at 0/0 are kept: see Clean.
+// If the sound sample tables can't be resolved, Media falls back to the
+// GPS time since the first fix.
+func (sgi *gpsRas) Fixes() ([]Fix,*UserData, error) {
+	gpsLogs, udata, err := sgi.GPSLogs()
+	if err != nil {
+		return nil, nil, err
+	}
+	media, ok := sgi.chunkTimes(len(gpsLogs))
+	fixes := make([]Fix, 0, len(gpsLogs))
+	for i := range gpsLogs {
+		fix, good := Decode(&gpsLogs[i])
+		if !good {
+			continue
+		}
+		fix.Offset = sgi.offsets[i]
+		switch {
+		case ok			: fix.Media = media[i]
+		case len(fixes) > 0	: fix.Media = fix.Time.Sub(fixes[0].Time)
+		}
+		fixes = append(fixes, fix)
+	}
+	Spread(fixes)
+	return fixes, udata, nil
+}
+//}}}
+//{{{  Spread(fixes) -- separate fixes which share a whole second
+// A receiver faster than 1 Hz, without fractions in its sentences, gives
+// runs of fixes with the same time. fixes must be in recording order, so
+// each run is spaced from the start of its second at the rate the runs
+// show. That is only believed when most runs agree on one rate above
+// 1 Hz: an odd duplicated block in a 1 Hz file is not a faster receiver.
+// A fix at the same place as the one before it in its run is a copy, and
+// keeps that one's time, so it still shows as repeated. A run cut short
+// by the start of the clip ends up a little early, which is as close as
+// the blocks allow.
+func Spread(fixes []Fix) {
+	//{{{  Runs, and how many places each has
+	var runs [][]Fix
+	counts := make(map[int]int)
+	for i := 0; i < len(fixes); {
+		j := i + 1
+		places := 1
+		for j < len(fixes) && !fixes[i].Precise && !fixes[j].Precise &&
+			fixes[j].Time.Equal(fixes[i].Time) {
+			if !samePlace(&fixes[j], &fixes[j-1]) {
+				places++
+			}
+			j++
+		}
+		runs = append(runs, fixes[i:j])
+		counts[places]++
+		i = j
+	}
+	//}}}
+	//{{{  The rate most runs show, if any
+	rate := 1
+	for n, c := range counts {
+		if n > 1 && 2*c > len(runs) {
+			rate = n
+		}
+	}
+	if rate == 1 {
+		return
+	}
+	//}}}
+	step := time.Second / time.Duration(rate)
+	for _, run := range runs {
+		k := 0
+		for i := range run {
+			if i > 0 && samePlace(&run[i], &run[i-1]) {
+				run[i].Time = run[i-1].Time
+				continue
+			}
+			if k == rate {
+				break		// more than the rate: leave the rest alone
+			}
+			run[i].Time = run[i].Time.Add(time.Duration(k) * step)
+			k++
+		}
+	}
+	if debug {
+		log.Printf("%d fixes share seconds: spaced at %v\n", len(fixes), step)
+	}
+}
+
+func samePlace(a, b *Fix) bool {
+	return a.Lat == b.Lat && a.Lon == b.Lon
+}
+//}}}
+//{{{  ClockOffset(created,fixes) -- camera clock minus GPS time
+// created is the camera's idea of when the clip started, normally the mvhd
+// creation time. The GPS time at the start of the clip is that of the first
+// fix less its media time. The result is rounded to the second, which is
+// all the camera records. ok is false if either time is missing.
+func ClockOffset(created time.Time, fixes []Fix) (offset time.Duration, ok bool) {
+	if created.IsZero() || len(fixes) == 0 {
+		return 0, false
+	}
+	start := fixes[0].Time.Add(-fixes[0].Media)
+	return created.Sub(start).Round(time.Second), true
+}
+//}}}
+
+//{{{  LerpAngle(a,b,f) -- interpolate course the short way round
+// Degrees, a fraction f from a to b, in [0,360).
+func LerpAngle(a, b, f float64) float64 {
+	d := math.Mod(b-a+540, 360) - 180
+	return math.Mod(a+f*d+360, 360)
+}
+//}}}
+//{{{  FixAt(fixes,t) -- interpolated fix at media time t
+// fixes must be in Media order, as Fixes returns them. Position, speed,
+// course and time are interpolated linearly between the fixes either side
+// of t: at 1 Hz the straight line is much closer than the fixes are good.
+func FixAt(fixes []Fix, t time.Duration) (Fix, error) {
+	for i := range fixes {
+		switch {
+		case fixes[i].Media == t:
+			return fixes[i], nil
+		case fixes[i].Media < t:
+			continue
+		case i == 0:
+			return Fix{}, ErrNotCovered
+		}
+		a, b := fixes[i-1], fixes[i]
+		f := float64(t-a.Media) / float64(b.Media-a.Media)
+		return Fix{
+			Time:		a.Time.Add(time.Duration(f * float64(b.Time.Sub(a.Time)))),
+			Lat:		a.Lat + f*(b.Lat-a.Lat),
+			Lon:		a.Lon + f*(b.Lon-a.Lon),
+			Speed:		a.Speed + f*(b.Speed-a.Speed),
+			Course:		LerpAngle(a.Course, b.Course, f),
+			Status:		a.Status,
+			Media:		t,
+			Offset:		a.Offset,
+			Constellation:	a.Constellation,
+		}, nil
+	}
+	return Fix{}, ErrNotCovered
+}
+//}}}
+//{{{  Passes(fixes,lat,lon,radius) -- when did the track come near?
+// Passes returns each run of consecutive fixes within radius metres of
+// lat,lon. An empty result means the clip never came that close.
+func Passes(fixes []Fix, lat, lon, radius float64) []Pass {
+	var passes []Pass
+	var cur *Pass
+	for _, fix := range fixes {
+		d := Distance(lat, lon, fix.Lat, fix.Lon)
+		if d > radius {
+			cur = nil
+			continue
+		}
+		if cur == nil {
+			passes = append(passes, Pass{Enter: fix.Media,
+					Closest: fix, Distance: d})
+			cur = &passes[len(passes)-1]
+		}
+		cur.Leave = fix.Media
+		if d < cur.Distance {
+			cur.Closest, cur.Distance = fix, d
+		}
+	}
+	return passes
+}
+//}}}
@@ -0,0 +1,184 @@
+//{{{  License
+// Copyright 2018,2019 A E Lawrence
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//}}}
+
+package nb
+
+//{{{  imports
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+//}}}
+
+//{{{  Integrity -- overview
+// A track can be wrong in ways Clean doesn't catch: a jammed or spoofed
+// receiver teleports, freezes while the car is moving, or repeats itself,
+// and a damaged file can send time backwards. Check compares each fix with
+// the one before and flags what doesn't add up. The flags are evidence to
+// look at, not proof: a tunnel or multipath in a city can raise them too.
+//}}}
+
+//{{{  type Flag -- set of integrity problems with one fix
+type Flag uint
+
+const (
+	ImpossibleSpeed	Flag = 1 << iota // too far from the previous fix in the time
+	SpeedMismatch		// reported speed disagrees with distance over time
+	Frozen			// position unchanged while reporting movement
+	Repeated		// same time and position as the previous fix
+	TimeReversal		// earlier than the previous fix
+	HDOPSpike		// dilution of precision far above the usual
+)
+
+var flagNames = []string{"impossible speed", "speed mismatch", "frozen",
+	"repeated", "time reversal", "HDOP spike"}
+//}}}
+//{{{  Method String -- comma separated names, "" if none
+func (f Flag) String() string {
+	var names []string
+	for i, name := range flagNames {
+		if f&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+//}}}
+//{{{  Thresholds
+const (
+	maxSpeed	= 100.0			// m/s, 360 km/h: no car does that
+	speedSlack	= 5.0			// m/s either side of reported speed
+	mismatchWindow	= 5 * time.Second	// longer gaps aren't straight lines
+	movingSpeed	= 2.0			// m/s: above this, position must change
+	hdopFloor	= 2.0			// below this hdop is never a spike
+	hdopFactor	= 3.0			// times the median hdop
+)
+//}}}
+//{{{  type Integrity -- the flags for each fix and a summary
+// Flags is in step with the fixes checked. ByBlock holds the same flags,
+// those not 0, keyed by the Offset of the fix's GPS block, for matching
+// them to points after the track has been processed. Counts holds how
+// many fixes have each single flag.
+type Integrity struct {
+	Flags	[]Flag
+	ByBlock	map[int64]Flag
+	Fixes	int
+	Flagged	int
+	Counts	map[Flag]int
+}
+//}}}
+//{{{  Check(fixes) -- integrity flags for a track
+// fixes should be in recording order, as Fixes returns them. Points at 0/0
+// have no position to check, so are skipped as neither fix nor previous.
+func Check(fixes []Fix) Integrity {
+	in := Integrity{
+		Flags:	make([]Flag, len(fixes)),
+		ByBlock:	make(map[int64]Flag),
+		Fixes:	len(fixes),
+		Counts:	make(map[Flag]int),
+	}
+	//{{{  The usual hdop, for spikes
+	var hdops []float64
+	for i := range fixes {
+		if h, ok := fixes[i].HDOP(); ok {
+			hdops = append(hdops, h)
+		}
+	}
+	spike := math.Inf(1)
+	if len(hdops) > 0 {
+		sort.Float64s(hdops)
+		spike = math.Max(hdopFloor, hdopFactor*hdops[len(hdops)/2])
+	}
+	//}}}
+	prev := -1
+	for i := range fixes {
+		b := &fixes[i]
+		if b.Lat == 0 && b.Lon == 0 {
+			continue
+		}
+		var f Flag
+		if h, ok := b.HDOP(); ok && h > spike {
+			f |= HDOPSpike
+		}
+		if prev >= 0 {
+			a := &fixes[prev]
+			dt := b.Time.Sub(a.Time).Seconds()
+			d := Distance(a.Lat, a.Lon, b.Lat, b.Lon)
+			//{{{  Time and repetition
+			switch {
+			case dt < 0:
+				f |= TimeReversal
+			case dt == 0 && d == 0:
+				f |= Repeated
+			}
+			//}}}
+			//{{{  Movement against reported speed
+			if dt > 0 {
+				v := d / dt
+				reported := (a.Speed + b.Speed) / 2
+				if v > maxSpeed {
+					f |= ImpossibleSpeed
+				}
+				if d == 0 && a.Speed > movingSpeed && b.Speed > movingSpeed {
+					f |= Frozen
+				}
+				if dt <= mismatchWindow.Seconds() &&
+					math.Abs(v-reported) > math.Max(speedSlack, reported/2) {
+					f |= SpeedMismatch
+				}
+			}
+			//}}}
+		}
+		in.Flags[i] = f
+		prev = i
+	}
+	//{{{  Summary counts
+	for i, f := range in.Flags {
+		if f != 0 {
+			in.Flagged++
+			if fixes[i].Offset >= 0 {
+				in.ByBlock[fixes[i].Offset] = f
+			}
+		}
+		for bit := Flag(1); bit <= HDOPSpike; bit <<= 1 {
+			if f&bit != 0 {
+				in.Counts[bit]++
+			}
+		}
+	}
+	//}}}
+	return in
+}
+//}}}
+//{{{  Method String -- one line summary
+// "120 fixes, none flagged" or "120 fixes, 3 flagged: 2 frozen, ..."
+func (in Integrity) String() string {
+	if in.Flagged == 0 {
+		return fmt.Sprintf("%d fixes, none flagged", in.Fixes)
+	}
+	var parts []string
+	for bit := Flag(1); bit <= HDOPSpike; bit <<= 1 {
+		if n := in.Counts[bit]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %v", n, bit))
+		}
+	}
+	return fmt.Sprintf("%d fixes, %d flagged: %s", in.Fixes, in.Flagged,
+		strings.Join(parts, ", "))
+}
+//}}}
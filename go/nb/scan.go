@@ -0,0 +1,239 @@
+//{{{  License
+// Copyright 2018,2019 A E Lawrence
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//}}}
+
+package nb
+
+//{{{  imports
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"log"
+)
+//}}}
+
+//{{{  Finding the GPS blocks -- overview
+// Each GPS block is a free atom written after a sound chunk. The Nextbase
+// firmware seen so far pads the sound chunk to 0x10000 bytes, so the block
+// is normally at usualGap from the chunk offset, but other firmware or
+// bitrate settings move it. So after trying the usual place, the block is
+// looked for: from the end of the sound samples in the chunk, for the atom
+// type and the "GPS " magic together. The search stops at the next sound
+// chunk, since a block beyond that belongs to it, or after scanWindow.
+// A chunk with no block is reported as missing, rather than reading
+// whatever is at the usual place.
+//}}}
+const (
+	usualGap	= 0x10000	// sound chunk offset to GPS block
+	scanWindow	= 0x40000	// furthest to look past the sound samples
+)
+
+var blockSignature = []byte("freeGPS ")	// atom type, then GPSLog.Magic
+
+//{{{  isBlock(r,off) -- does a GPS block start at off?
+func isBlock(r io.ReaderAt, off int64) bool {
+	b := make([]byte, len(blockSignature))
+	_, err := r.ReadAt(b, off+4)
+	return err == nil && bytes.Equal(b, blockSignature)
+}
+//}}}
+//{{{  scanBlock(r,from,to) -- first GPS block starting in [from,to)
+// ok is false if there is none. Running into the end of the file is not
+// an error: the block just isn't there.
+func scanBlock(r io.ReaderAt, from, to int64) (off int64, ok bool, err error) {
+	if to <= from {
+		return 0, false, nil
+	}
+	buf := make([]byte, to-from-1+int64(len(blockSignature)))
+	n, err := r.ReadAt(buf, from+4)
+	if err != nil && err != io.EOF {
+		return 0, false, err
+	}
+	i := bytes.Index(buf[:n], blockSignature)
+	if i < 0 {
+		return 0, false, nil
+	}
+	return from + int64(i), true, nil
+}
+//}}}
+//{{{  Method chunkEnds(chunks) -- end of the sound samples in each chunk
+// Where the sample tables can't be resolved, the chunk offset itself:
+// the search then just starts a little early.
+func (sgi *gpsRas) chunkEnds(chunks []uint64) []int64 {
+	ends := make([]int64, len(chunks))
+	for i, c := range chunks {
+		ends[i] = int64(c)
+	}
+	if sgi.sound == nil {
+		return ends
+	}
+	sound, err := sgi.sound.Chunks()
+	if err != nil {
+		if debug {
+			log.Printf("sound chunks: %v\n", err)
+		}
+		return ends
+	}
+	for i, ch := range sound {
+		if i < len(ends) && ch.Offset+ch.Size > ends[i] {
+			ends[i] = ch.Offset + ch.Size
+		}
+	}
+	return ends
+}
+//}}}
+//{{{  Method findBlocks(chunks) -- offset of the GPS block after each chunk
+// -1 where the block is missing.
+func (sgi *gpsRas) findBlocks(chunks []uint64) ([]int64, error) {
+	ends := sgi.chunkEnds(chunks)
+	offsets := make([]int64, len(chunks))
+	for i, c := range chunks {
+		from, to := ends[i], ends[i]+scanWindow
+		if i+1 < len(chunks) && int64(chunks[i+1]) > int64(c) &&
+			int64(chunks[i+1]) < to {
+			to = int64(chunks[i+1])
+		}
+		//{{{  The usual place first: no need to read the whole gap
+		if usual := int64(c) + usualGap; usual < to && isBlock(sgi.ras, usual) {
+			offsets[i] = usual
+			continue
+		}
+		//}}}
+		off, ok, err := scanBlock(sgi.ras, from, to)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case !ok:
+			off = -1
+			if debug {
+				log.Printf("chunk %d at %#x: no GPS block before %#x\n",
+					i, c, to)
+			}
+		case debug:
+			log.Printf("chunk %d at %#x: GPS block at +%#x\n",
+				i, c, off-int64(c))
+		}
+		offsets[i] = off
+	}
+	return offsets, nil
+}
+//}}}
+//{{{  Scanning without sample tables -- overview
+// When the power is cut mid-recording the camera never writes moov, so
+// there are no sound chunk offsets to start from. The GPS blocks are still
+// in mdat, and their signature is distinctive enough to find by reading
+// the whole file: ftyp and mdat are all there is, and a partial moov, if
+// any, can't hold it. The same works on any stream, like a card image.
+// A block cut short by the end of the stream is dropped.
+//}}}
+const scanBuffer = 1 << 20	// bytes read at a time by ScanLogs
+
+//{{{  ScanLogs(r,found) -- every GPS block in r, in order
+// found is called with the offset of each block from where r started, and
+// the block decoded. An error from found stops the scan and is returned.
+func ScanLogs(r io.Reader, found func(off int64, g *GPSLog) error) error {
+	size := binary.Size(GPSLog{})
+	buf := make([]byte, 0, scanBuffer+size)
+	var base int64		// offset of buf[0]
+	for {
+		//{{{  Top up the buffer
+		n, err := io.ReadFull(r, buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		eof := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !eof {
+			return err
+		}
+		//}}}
+		//{{{  Decode every block which fits in the buffer
+		// A signature in the first 4 bytes belongs to a block which
+		// started in the tail kept last time round, so was done then.
+		next := 0
+		for {
+			i := bytes.Index(buf[next:], blockSignature)
+			start := next + i - 4
+			if i < 0 || start+size > len(buf) {
+				break
+			}
+			if start >= 0 {
+				var g GPSLog
+				binary.Read(bytes.NewReader(buf[start:start+size]),
+					binary.LittleEndian, &g)
+				if err := found(base+int64(start), &g); err != nil {
+					return err
+				}
+			}
+			next += i + 1
+		}
+		//}}}
+		if eof {
+			return nil
+		}
+		//{{{  Keep the tail, which may hold the start of a block
+		keep := len(buf) - size
+		if keep < next {
+			keep = next
+		}
+		base += int64(keep)
+		buf = buf[:copy(buf, buf[keep:])]
+		//}}}
+	}
+}
+//}}}
+//{{{  Method scanLogs -- GPSLogs and offsets by reading the whole file
+func (sgi *gpsRas) scanLogs() ([]GPSLog, error) {
+	if _, err := sgi.ras.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	gpsLogs := []GPSLog{}	// not nil, even if none: see GPSLogs
+	sgi.offsets = nil
+	err := ScanLogs(sgi.ras, func(off int64, g *GPSLog) error {
+		gpsLogs = append(gpsLogs, *g)
+		sgi.offsets = append(sgi.offsets, off)
+		return nil
+	})
+	if debug {
+		log.Printf("scan found %d GPS blocks\n", len(gpsLogs))
+	}
+	return gpsLogs, err
+}
+//}}}
+//{{{  Method Missing -- sound chunks without a GPS block
+// Indices into the sound chunks, so into the GPSLogs, whose entries are
+// zeroed (and so Rubbish) there. Only meaningful after GPSLogs, and always
+// empty when the blocks were Scanned.
+func (sgi *gpsRas) Missing() []int {
+	var missing []int
+	for i, off := range sgi.offsets {
+		if off < 0 {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+//}}}
+//{{{  Method Offsets -- file offset of each GPS block, -1 if missing
+// In step with GPSLogs, and only meaningful after it.
+func (sgi *gpsRas) Offsets() []int64 {
+	return sgi.offsets
+}
+//}}}
+//{{{  Method Scanned -- were the blocks found by reading the whole file?
+// True for NewRecoveryInfo, or when there were no sound chunks to go by.
+func (sgi *gpsRas) Scanned() bool {
+	return sgi.scanned
+}
+//}}}
@@ -0,0 +1,62 @@
+//{{{  License
+// Copyright 2016 KB Sriram
+// Copyright 2018,2019 A E Lawrence
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//}}}
+
+package nb
+
+//{{{  imports
+import (
+	"github.com/clarified/mov2gps/go/nmea"
+)
+//}}}
+
+//{{{  sentence(magic,entries) -- rejoin the split sentence and parse
+// GPSLog splits each sentence after its address, so put it back together.
+// The trailing zero padding is dealt with by nmea.Parse.
+func sentence(magic []byte, entries []byte) (nmea.Sentence, error) {
+	b := make([]byte, 0, len(magic)+len(entries))
+	return nmea.Parse(append(append(b, magic...), entries...))
+}
+//}}}
+//{{{  Method RMC -- checked RMC sentence from the log
+// An error means there is no RMC sentence, or it is truncated or corrupt.
+func (g *GPSLog) RMC() (*nmea.RMC, error) {
+	s, err := sentence(g.MagicRMC[:], g.RMCentries[:])
+	if err != nil {
+		return nil, err
+	}
+	return s.RMC()
+}
+//}}}
+//{{{  Method HasRMC, HasGGA -- is there a sentence, good or bad?
+// Any constellation: $GPRMC, $GNRMC, $GLRMC...
+func (g *GPSLog) HasRMC() bool {
+	return nmea.IsAddress(g.MagicRMC[:], "RMC")
+}
+
+func (g *GPSLog) HasGGA() bool {
+	return nmea.IsAddress(g.MagicGGA[:], "GGA")
+}
+//}}}
+//{{{  Method GGA -- checked GGA sentence from the log
+func (g *GPSLog) GGA() (*nmea.GGA, error) {
+	s, err := sentence(g.MagicGGA[:], g.GGAentries[:])
+	if err != nil {
+		return nil, err
+	}
+	return s.GGA()
+}
+//}}}
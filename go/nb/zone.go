@@ -0,0 +1,155 @@
+//{{{  License
+// Copyright 2016 KB Sriram
+// Copyright 2018,2019 A E Lawrence
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//}}}
+
+package nb
+
+//{{{  imports
+import (
+	"fmt"
+	"time"
+)
+//}}}
+
+//{{{  Displayed local time -- overview
+// Later firmware writes the date and time shown on the screen as ASCII
+// digits in the GPSLog (LocalYear...LocalSec). That is the camera's local
+// time, including any summer time adjustment set by the user, and seems
+// to lag the UTC fields by a couple of seconds. Comparing the two gives
+// the offset the camera is set to, which is always a whole number of
+// quarter hours, so rounding absorbs the lag.
+//}}}
+
+//{{{  type Zone -- camera's configured offset from UTC
+// Offset is camera local time minus UTC. Summer is true when Offset is an
+// hour ahead of the standard time of the location used to infer it, and
+// that location has summer time.
+type Zone struct {
+	Offset	time.Duration
+	Summer	bool
+}
+//}}}
+//{{{  Method Location -- fixed zone for formatting local times
+func (z Zone) Location() *time.Location {
+	return time.FixedZone(z.String(), int(z.Offset.Seconds()))
+}
+//}}}
+//{{{  Method String -- UTC+hh:mm
+func (z Zone) String() string {
+	sign, off := '+', z.Offset
+	if off < 0 {
+		sign, off = '-', -off
+	}
+	return fmt.Sprintf("UTC%c%02d:%02d", sign, int(off.Hours()),
+		int(off.Minutes())%60)
+}
+//}}}
+
+//{{{  digits(b) -- value of ASCII digits, ok false if not all digits
+func digits(b []byte) (int, bool) {
+	v := 0
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		v = v*10 + int(c-'0')
+	}
+	return v, len(b) > 0
+}
+//}}}
+//{{{  Method Local -- the displayed local time, if present
+// The result is the wall clock time shown by the camera, labelled UTC for
+// want of anything better. ok is false if the fields are absent (zeroed in
+// earlier firmware) or not a real date and time.
+func (g *GPSLog) Local() (t time.Time, ok bool) {
+	var f [6]int
+	for i, b := range [][]byte{g.LocalYear[:], g.LocalMon[:], g.LocalDay[:],
+			g.LocalHour[:], g.LocalMin[:], g.LocalSec[:]} {
+		if f[i], ok = digits(b); !ok {
+			return time.Time{}, false
+		}
+	}
+	t = time.Date(f[0], time.Month(f[1]), f[2], f[3], f[4], f[5], 0, time.UTC)
+	//{{{  time.Date normalises nonsense like month 13: reject that
+	if t.Month() != time.Month(f[1]) || t.Day() != f[2] || t.Hour() != f[3] ||
+		t.Minute() != f[4] || t.Second() != f[5] {
+		return time.Time{}, false
+	}
+	//}}}
+	return t, true
+}
+//}}}
+//{{{  Method UTCOffset -- displayed local time minus UTC
+// Rounded to the quarter hour. ok is false without a displayed time or a
+// usable UTC time.
+func (g *GPSLog) UTCOffset() (time.Duration, bool) {
+	local, ok := g.Local()
+	if !ok || g.Mon == 0 {
+		return 0, false
+	}
+	return local.Sub(g.UTC()).Round(15 * time.Minute), true
+}
+//}}}
+//{{{  standardOffset(loc,year) -- offset of loc without summer time
+// Standard time is the smaller of the January and July offsets, which
+// holds in both hemispheres. summer is false if loc has no summer time.
+func standardOffset(loc *time.Location, year int) (std time.Duration,
+			summer bool) {
+	_, jan := time.Date(year, 1, 1, 12, 0, 0, 0, loc).Zone()
+	_, jul := time.Date(year, 7, 1, 12, 0, 0, 0, loc).Zone()
+	summer = jan != jul
+	if jul < jan {
+		jan = jul
+	}
+	return time.Duration(jan) * time.Second, summer
+}
+//}}}
+//{{{  InferZone(gpsLogs,loc) -- camera zone from the displayed times
+// The most common offset over all the blocks is taken, so that odd blocks
+// (the displayed time is sometimes stale at the start of a file) don't
+// matter. loc says where the camera is used, to decide whether the offset
+// includes summer time; with nil that isn't decided, and Summer is false.
+// Passing time.Local makes Summer depend on the machine doing the
+// converting, not the camera.
+// ok is false if no block has a displayed time.
+func InferZone(gpsLogs []GPSLog, loc *time.Location) (Zone, bool) {
+	counts := make(map[time.Duration]int)
+	var best time.Duration
+	var bestN, year int
+	for i := range gpsLogs {
+		if gpsLogs[i].Rubbish(false) {
+			continue
+		}
+		off, ok := gpsLogs[i].UTCOffset()
+		if !ok {
+			continue
+		}
+		counts[off]++
+		if counts[off] > bestN {
+			best, bestN, year = off, counts[off], gpsLogs[i].UTC().Year()
+		}
+	}
+	if len(counts) == 0 {
+		return Zone{}, false
+	}
+	zone := Zone{Offset: best}
+	if loc != nil {
+		std, summer := standardOffset(loc, year)
+		zone.Summer = summer && best == std+time.Hour
+	}
+	return zone, true
+}
+//}}}
@@ -0,0 +1,460 @@
+//{{{  license
+// Copyright 2018,2019 A E Lawrence
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//}}}
+
+// Package nmea parses the NMEA 0183 sentences that cameras embed beside
+// their binary GPS records: RMC, GGA, GSA, GSV and VTG.
+// http://aprs.gids.nl/nmea/ may help.
+package nmea
+
+//{{{  imports
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+//}}}
+
+var (
+	ErrNoSentence	= errors.New("Not an NMEA sentence")
+	ErrNoChecksum	= errors.New("NMEA sentence truncated: no checksum")
+	ErrChecksum	= errors.New("NMEA checksum mismatch")
+	ErrWrongType	= errors.New("NMEA sentence of unexpected type")
+)
+
+//{{{  type Sentence -- checked, but otherwise raw
+// Sentence is a sentence whose checksum has been verified, split into
+// fields. Fields excludes the address ("GPGGA"): Fields[0] is the first
+// data field, numbered as the constants below.
+// The valid bits are set by the typed decoders for each field that was
+// present and parsed. Only the standard satellite talkers are accepted.
+type Sentence struct {
+	Talker	string		// "GP", "GN", "GL", ...
+	Type	string		// "RMC", "GGA", ...
+	Fields	[]string
+	valid	uint64
+}
+//}}}
+//{{{  Method Field(i) -- raw text of field i, "" if absent
+func (s *Sentence) Field(i int) string {
+	if i < 0 || i >= len(s.Fields) {
+		return ""
+	}
+	return s.Fields[i]
+}
+//}}}
+//{{{  Method Valid(i) -- was field i present and sensible?
+func (s *Sentence) Valid(i int) bool {
+	return i >= 0 && i < 64 && s.valid&(1<<uint(i)) != 0
+}
+//}}}
+
+//{{{  Talkers -- which constellation a sentence comes from
+// Receivers using more than one system say GN for combined solutions,
+// and may also report each system under its own talker.
+var constellations = map[string]string{
+	"GP":	"GPS",
+	"GL":	"GLONASS",
+	"GA":	"Galileo",
+	"GB":	"BeiDou",
+	"BD":	"BeiDou",
+	"GQ":	"QZSS",
+	"QZ":	"QZSS",
+	"GI":	"NavIC",
+	"GN":	"GNSS",		// combined
+}
+//}}}
+//{{{  Constellation(talker) -- name of the system, "" if unknown
+func Constellation(talker string) string {
+	return constellations[talker]
+}
+//}}}
+//{{{  IsAddress(b,typ) -- does b start "$ttTYP," for a known talker?
+// Used to spot a sentence of type typ before the effort of parsing it.
+func IsAddress(b []byte, typ string) bool {
+	return len(b) >= 4+len(typ) && b[0] == '$' &&
+		constellations[string(b[1:3])] != "" &&
+		string(b[3:3+len(typ)]) == typ && b[3+len(typ)] == ','
+}
+//}}}
+
+//{{{  Parse(b) -- check and split a sentence
+// b may carry the trailing zero padding that cameras write after the
+// sentence, and the usual CR LF. The checksum is required: a sentence
+// without one is assumed to have been truncated.
+func Parse(b []byte) (Sentence, error) {
+	var s Sentence
+	b = bytes.TrimRight(b, "\x00\r\n ")
+	//{{{  $ address
+	if len(b) < 7 || b[0] != '$' {
+		return s, ErrNoSentence
+	}
+	//}}}
+	//{{{  *hh checksum
+	star := bytes.LastIndexByte(b, '*')
+	if star < 0 || len(b)-star != 3 {
+		return s, ErrNoChecksum
+	}
+	want, err := strconv.ParseUint(string(b[star+1:]), 16, 8)
+	if err != nil {
+		return s, ErrNoChecksum
+	}
+	var sum byte
+	for _, c := range b[1:star] {
+		sum ^= c
+	}
+	if sum != byte(want) {
+		return s, ErrChecksum
+	}
+	//}}}
+	fields := strings.Split(string(b[1:star]), ",")
+	if len(fields[0]) != 5 {
+		return s, ErrNoSentence
+	}
+	s.Talker, s.Type, s.Fields = fields[0][:2], fields[0][2:], fields[1:]
+	if Constellation(s.Talker) == "" {
+		return s, ErrNoSentence
+	}
+	return s, nil
+}
+//}}}
+
+//{{{  Field parsers -- each sets the valid bit(s) on success
+//{{{  Method float(i)
+func (s *Sentence) float(i int) float64 {
+	v, err := strconv.ParseFloat(s.Field(i), 64)
+	if err != nil {
+		return 0
+	}
+	s.valid |= 1 << uint(i)
+	return v
+}
+//}}}
+//{{{  Method int(i)
+func (s *Sentence) int(i int) int {
+	v, err := strconv.Atoi(s.Field(i))
+	if err != nil {
+		return 0
+	}
+	s.valid |= 1 << uint(i)
+	return v
+}
+//}}}
+//{{{  Method char(i,allowed) -- single character from allowed
+func (s *Sentence) char(i int, allowed string) byte {
+	f := s.Field(i)
+	if len(f) != 1 || !strings.Contains(allowed, f) {
+		return 0
+	}
+	s.valid |= 1 << uint(i)
+	return f[0]
+}
+//}}}
+//{{{  Method clock(i) -- hhmmss[.sss] as time since midnight
+func (s *Sentence) clock(i int) time.Duration {
+	f := s.Field(i)
+	if len(f) < 6 {
+		return 0
+	}
+	h, err1 := strconv.Atoi(f[0:2])
+	m, err2 := strconv.Atoi(f[2:4])
+	sec, err3 := strconv.ParseFloat(f[4:], 64)
+	if err1 != nil || err2 != nil || err3 != nil ||
+		h > 23 || m > 59 || sec >= 61 {
+		return 0
+	}
+	s.valid |= 1 << uint(i)
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute +
+		time.Duration(sec*float64(time.Second)+0.5)
+}
+//}}}
+//{{{  Method date(i) -- ddmmyy as midnight UTC
+func (s *Sentence) date(i int) time.Time {
+	f := s.Field(i)
+	if len(f) != 6 {
+		return time.Time{}
+	}
+	d, err1 := strconv.Atoi(f[0:2])
+	m, err2 := strconv.Atoi(f[2:4])
+	y, err3 := strconv.Atoi(f[4:6])
+	if err1 != nil || err2 != nil || err3 != nil ||
+		d < 1 || d > 31 || m < 1 || m > 12 {
+		return time.Time{}
+	}
+	s.valid |= 1 << uint(i)
+	return time.Date(2000+y, time.Month(m), d, 0, 0, 0, 0, time.UTC)
+}
+//}}}
+//{{{  Method coord(i,hemispheres) -- (d)ddmm.mmmm & hemisphere as degrees
+// The value is field i, the hemisphere field i+1. Both valid bits are
+// set, or neither. South and West are negative.
+func (s *Sentence) coord(i int, hemispheres string) float64 {
+	f := s.Field(i)
+	dot := strings.IndexByte(f, '.')
+	if dot < 0 {
+		dot = len(f)
+	}
+	if dot < 3 {
+		return 0
+	}
+	deg, err1 := strconv.Atoi(f[:dot-2])
+	min, err2 := strconv.ParseFloat(f[dot-2:], 64)
+	h := s.Field(i + 1)
+	if err1 != nil || err2 != nil || min >= 60 ||
+		len(h) != 1 || !strings.Contains(hemispheres, h) {
+		return 0
+	}
+	s.valid |= 3 << uint(i)
+	v := float64(deg) + min/60
+	if h == "S" || h == "W" {
+		v = -v
+	}
+	return v
+}
+//}}}
+//}}}
+
+//{{{  RMC
+//{{{  RMC field indices
+const (
+	RMCTime		= iota	// hour-min-sec without separators
+	RMCStatus		// A - valid, V - warning
+	RMCLat			// Latitude
+	RMCNorS			// North or South (N|S)
+	RMCLon			// Longitude
+	RMCEorW			// East or West (E|W)
+	RMCSpeed		// Speed in knots (1.852 kM/hr)
+	RMCCourse		// Course (degrees, true)
+	RMCDate			// Date (ddmmyy)
+	RMCMagVar		// Mag variation
+	RMCMagEorW		// (E|W) of variation
+	RMCMode			// NMEA 2.3 on: A,D,E,N,...
+)
+//}}}
+// RMC -- recommended minimum. Time is since midnight UTC on Date.
+type RMC struct {
+	Sentence
+	Time	time.Duration
+	Status	byte
+	Lat	float64
+	Lon	float64
+	Speed	float64		// knots
+	Course	float64
+	Date	time.Time
+	Mode	byte
+}
+
+func (s Sentence) RMC() (*RMC, error) {
+	if s.Type != "RMC" {
+		return nil, ErrWrongType
+	}
+	r := &RMC{Sentence: s}
+	r.Time = r.clock(RMCTime)
+	r.Status = r.char(RMCStatus, "AV")
+	r.Lat = r.coord(RMCLat, "NS")
+	r.Lon = r.coord(RMCLon, "EW")
+	r.Speed = r.float(RMCSpeed)
+	r.Course = r.float(RMCCourse)
+	r.Date = r.date(RMCDate)
+	r.Mode = r.char(RMCMode, "ADEFMNPRS")
+	return r, nil
+}
+//}}}
+//{{{  GGA
+//{{{  GGA field indices
+const (
+	GGATime		= iota	// hour-min-sec without separators
+	GGALat			// Latitude
+	GGANorS			// North or South (N|S)
+	GGALon			// Longitude
+	GGAEorW			// East or West (E|W)
+	GGAQuality		// 0 for no fix, 1 for gps, 2 for dgps
+	GGASats			// No of satelites in use
+	GGAHDOP			// Horizontal dilution of prec.
+	GGAAltitude		// Height
+	GGAAltUnit		// Height unit (Metres normally)
+	GGAGeoid		// Geoid separation
+	GGAGeoidUnit		// Geo sep unit (Metres normally)
+	GGADGPSAge		// Age of differential data
+	GGADGPSID		// Differential station
+)
+//}}}
+// GGA -- fix data. Altitude is above mean sea level, Geoid the height of
+// the geoid above the WGS84 ellipsoid.
+type GGA struct {
+	Sentence
+	Time		time.Duration
+	Lat		float64
+	Lon		float64
+	Quality		int
+	Sats		int
+	HDOP		float64
+	Altitude	float64
+	AltUnit		byte
+	Geoid		float64
+	GeoidUnit	byte
+	DGPSAge		float64
+	DGPSID		int
+}
+
+func (s Sentence) GGA() (*GGA, error) {
+	if s.Type != "GGA" {
+		return nil, ErrWrongType
+	}
+	g := &GGA{Sentence: s}
+	g.Time = g.clock(GGATime)
+	g.Lat = g.coord(GGALat, "NS")
+	g.Lon = g.coord(GGALon, "EW")
+	g.Quality = g.int(GGAQuality)
+	g.Sats = g.int(GGASats)
+	g.HDOP = g.float(GGAHDOP)
+	g.Altitude = g.float(GGAAltitude)
+	g.AltUnit = g.char(GGAAltUnit, "M")
+	g.Geoid = g.float(GGAGeoid)
+	g.GeoidUnit = g.char(GGAGeoidUnit, "M")
+	g.DGPSAge = g.float(GGADGPSAge)
+	g.DGPSID = g.int(GGADGPSID)
+	return g, nil
+}
+//}}}
+//{{{  GSA
+//{{{  GSA field indices
+// Fields GSAPRN to GSAPRN+11 are the satellites used.
+const (
+	GSAMode		= iota	// M manual, A automatic 2D/3D
+	GSAFixType		// 1 no fix, 2 2D, 3 3D
+	GSAPRN			// first of 12 satellite PRNs
+	GSAPDOP		= GSAPRN + 12
+	GSAHDOP		= GSAPRN + 13
+	GSAVDOP		= GSAPRN + 14
+	GSASystem	= GSAPRN + 15	// NMEA 4.1 GNSS system ID
+)
+//}}}
+// GSA -- satellites and dilution of precision
+type GSA struct {
+	Sentence
+	Mode	byte
+	FixType	int
+	PRNs	[]int
+	PDOP	float64
+	HDOP	float64
+	VDOP	float64
+}
+
+func (s Sentence) GSA() (*GSA, error) {
+	if s.Type != "GSA" {
+		return nil, ErrWrongType
+	}
+	g := &GSA{Sentence: s}
+	g.Mode = g.char(GSAMode, "MA")
+	g.FixType = g.int(GSAFixType)
+	for i := GSAPRN; i < GSAPRN+12; i++ {
+		if prn := g.int(i); g.Valid(i) {
+			g.PRNs = append(g.PRNs, prn)
+		}
+	}
+	g.PDOP = g.float(GSAPDOP)
+	g.HDOP = g.float(GSAHDOP)
+	g.VDOP = g.float(GSAVDOP)
+	return g, nil
+}
+//}}}
+//{{{  GSV
+//{{{  GSV field indices
+// Each satellite takes four fields from GSVSat: PRN, elevation,
+// azimuth and SNR.
+const (
+	GSVTotal	= iota	// sentences in this group
+	GSVNumber		// this sentence, from 1
+	GSVInView		// satellites in view
+	GSVSat			// first satellite
+)
+//}}}
+// SatInfo -- one satellite in view. SNR is 0 when not tracked.
+type SatInfo struct {
+	PRN		int
+	Elevation	int
+	Azimuth		int
+	SNR		int
+}
+// GSV -- satellites in view, up to four per sentence
+type GSV struct {
+	Sentence
+	Total	int
+	Number	int
+	InView	int
+	Sats	[]SatInfo
+}
+
+func (s Sentence) GSV() (*GSV, error) {
+	if s.Type != "GSV" {
+		return nil, ErrWrongType
+	}
+	g := &GSV{Sentence: s}
+	g.Total = g.int(GSVTotal)
+	g.Number = g.int(GSVNumber)
+	g.InView = g.int(GSVInView)
+	for i := GSVSat; i+3 < len(g.Fields) && i+3 < 64; i += 4 {
+		sat := SatInfo{PRN: g.int(i)}
+		if !g.Valid(i) {
+			continue
+		}
+		sat.Elevation = g.int(i + 1)
+		sat.Azimuth = g.int(i + 2)
+		sat.SNR = g.int(i + 3)
+		g.Sats = append(g.Sats, sat)
+	}
+	return g, nil
+}
+//}}}
+//{{{  VTG
+//{{{  VTG field indices
+const (
+	VTGCourse	= iota	// degrees true
+	VTGT			// "T"
+	VTGMagCourse		// degrees magnetic
+	VTGM			// "M"
+	VTGKnots		// speed
+	VTGN			// "N"
+	VTGKmh			// speed
+	VTGK			// "K"
+	VTGMode			// NMEA 2.3 on
+)
+//}}}
+// VTG -- course and speed over ground
+type VTG struct {
+	Sentence
+	Course		float64
+	MagCourse	float64
+	Knots		float64
+	Kmh		float64
+	Mode		byte
+}
+
+func (s Sentence) VTG() (*VTG, error) {
+	if s.Type != "VTG" {
+		return nil, ErrWrongType
+	}
+	v := &VTG{Sentence: s}
+	v.Course = v.float(VTGCourse)
+	v.MagCourse = v.float(VTGMagCourse)
+	v.Knots = v.float(VTGKnots)
+	v.Kmh = v.float(VTGKmh)
+	v.Mode = v.char(VTGMode, "ADEFMNPRS")
+	return v, nil
+}
+//}}}
@@ -0,0 +1,85 @@
+//{{{  License
+// Copyright 2018,2019 A E Lawrence
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//}}}
+
+package track
+
+//{{{  imports
+import (
+	"github.com/clarified/mov2gps/go/nb"
+	"sort"
+	"time"
+)
+//}}}
+
+//{{{  Filling gaps -- overview
+// Blocks with no time or a 0/0 position are dropped, as are void and
+// outlying fixes if asked, which leaves holes in the timeline that
+// subtitles and overlays stumble on. Fill puts points back at the usual
+// step across each gap no longer than a limit, on the straight line
+// between the fixes either side. They are marked Synthetic, and carry
+// nothing which only a real fix could give: no sentences, no status and
+// no block offset. A longer gap is left alone: nothing is known about
+// where the vehicle went.
+//}}}
+
+//{{{  Step(fixes) -- the usual time between fixes, 0 if unknown
+// The median of the times between fixes in order, ignoring any which
+// share a time.
+func Step(fixes []nb.Fix) time.Duration {
+	var dts []time.Duration
+	for i := 1; i < len(fixes); i++ {
+		if dt := fixes[i].Time.Sub(fixes[i-1].Time); dt > 0 {
+			dts = append(dts, dt)
+		}
+	}
+	if len(dts) == 0 {
+		return 0
+	}
+	sort.Slice(dts, func(i, j int) bool { return dts[i] < dts[j] })
+	return dts[len(dts)/2]
+}
+//}}}
+//{{{  Fill(fixes,limit) -- gaps up to limit filled with synthetic points
+// fixes should be in time order. Returns them with the points added, and
+// how many were.
+func Fill(fixes []nb.Fix, limit time.Duration) ([]nb.Fix, int) {
+	step := Step(fixes)
+	if step == 0 {
+		return append(fixes[:0:0], fixes...), 0
+	}
+	out := fixes[:0:0]
+	added := 0
+	for i := range fixes {
+		if i > 0 {
+			a, b := &fixes[i-1], &fixes[i]
+			dt := b.Time.Sub(a.Time)
+			if dt > step*3/2 && dt <= limit {
+				//{{{  Evenly across the gap, as near the usual step as fits
+				n := int((dt + step/2) / step)
+				for k := 1; k < n; k++ {
+					fix := interpolate(a, b, float64(k)/float64(n), Linear)
+					fix.Status, fix.Synthetic = 0, true
+					out = append(out, fix)
+					added++
+				}
+				//}}}
+			}
+		}
+		out = append(out, fixes[i])
+	}
+	return out, added
+}
+//}}}
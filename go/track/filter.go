@@ -0,0 +1,184 @@
+//{{{  License
+// Copyright 2018,2019 A E Lawrence
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//}}}
+
+// Package track holds processing stages for decoded fixes, between
+// nb.GPSInfo.Fixes and the writers. Each stage takes fixes in time order
+// and returns new ones, leaving its input alone.
+package track
+
+//{{{  imports
+import (
+	"fmt"
+	"github.com/clarified/mov2gps/go/nb"
+	"math"
+)
+//}}}
+
+//{{{  Outliers -- overview
+// Clean only drops the 0/0 points. After a cold start, and in tunnels, a
+// receiver also gives single fixes kilometres from the truth. Such a fix
+// can't be reached from the fix before at a plausible speed, nor left for
+// the fix after, while skipping it joins the two neighbours plausibly. A
+// fix which merely can't be reached, but leads on plausibly, is where the
+// track really went, perhaps after a gap: it is kept.
+// Implied speeds are noisy, since each position is only good to a few
+// metres, so acceleration is judged allowing speedNoise, and over at
+// least a second.
+//}}}
+const speedNoise = 5.0	// m/s of implied speed which is just position noise
+
+//{{{  type Limits -- what a vehicle can plausibly do
+type Limits struct {
+	MaxSpeed	float64	// m/s
+	MaxAccel	float64	// m/s², either way
+	Repair		bool	// move outliers between their neighbours, not drop them
+}
+//}}}
+//{{{  type Outlier -- a fix removed or repaired, and why
+// Fix is as it was before any repair.
+type Outlier struct {
+	Fix		nb.Fix
+	Reason		string
+	Repaired	bool
+}
+//}}}
+//{{{  Method String -- one line for a verbose report
+func (o Outlier) String() string {
+	what := "removed"
+	if o.Repaired {
+		what = "repaired"
+	}
+	return fmt.Sprintf("%s %.6f,%.6f at %#x: %s, %s",
+		o.Fix.Time.Format("15:04:05.0"), o.Fix.Lat, o.Fix.Lon,
+		o.Fix.Offset, o.Reason, what)
+}
+//}}}
+
+//{{{  speed(a,b) -- implied m/s from a to b, ok false if no time between
+func speed(a, b *nb.Fix) (float64, bool) {
+	dt := b.Time.Sub(a.Time).Seconds()
+	if dt <= 0 {
+		return 0, false
+	}
+	return nb.Distance(a.Lat, a.Lon, b.Lat, b.Lon) / dt, true
+}
+//}}}
+//{{{  Method jump(before,a,b) -- why going a to b is implausible, "" if not
+// before is the fix ahead of a, for the speed at a; nil to use the speed
+// a reports. Fixes with no time between them are not judged.
+func (lim Limits) jump(before, a, b *nb.Fix) string {
+	v, ok := speed(a, b)
+	if !ok {
+		return ""
+	}
+	if v > lim.MaxSpeed {
+		return fmt.Sprintf("%.0f km/h", v*3.6)
+	}
+	v0 := a.Speed
+	if before != nil {
+		if u, ok := speed(before, a); ok {
+			v0 = u
+		}
+	}
+	dt := math.Max(1, b.Time.Sub(a.Time).Seconds())
+	if accel := (math.Abs(v-v0) - speedNoise) / dt; accel > lim.MaxAccel {
+		return fmt.Sprintf("%.0f m/s² acceleration", accel)
+	}
+	return ""
+}
+//}}}
+//{{{  Filter(fixes,lim) -- without the outliers, or with them repaired
+// Returns the fixes kept, in order, and the outliers in order.
+// A repaired fix keeps its time and the rest of its fields, but its
+// position is put on the line between the kept fixes either side. An
+// outlier at either end has only one neighbour, so is always removed.
+func Filter(fixes []nb.Fix, lim Limits) ([]nb.Fix, []Outlier) {
+	bad := make([]string, len(fixes))
+	//{{{  Find the outliers
+	prev, before := -1, -1		// last two fixes kept
+	for i := range fixes {
+		b := &fixes[i]
+		var next *nb.Fix
+		if i+1 < len(fixes) {
+			next = &fixes[i+1]
+		}
+		switch {
+		case prev >= 0:
+			var pb *nb.Fix
+			if before >= 0 {
+				pb = &fixes[before]
+			}
+			a := &fixes[prev]
+			why := lim.jump(pb, a, b)
+			if why != "" && (next == nil || lim.jump(a, b, next) != "" ||
+				lim.jump(pb, a, next) == "") {
+				bad[i] = why + " from the fix before"
+			}
+		//{{{  Cold start: the first fix has only those after to go by
+		case next != nil && i+2 < len(fixes):
+			if lim.jump(nil, b, next) != "" && lim.jump(nil, next, &fixes[i+2]) == "" {
+				bad[i] = lim.jump(nil, b, next) + " to the fix after"
+			}
+		//}}}
+		}
+		if bad[i] == "" {
+			before, prev = prev, i
+		}
+	}
+	//}}}
+	kept := fixes[:0:0]
+	var outliers []Outlier
+	for i, fix := range fixes {
+		if bad[i] == "" {
+			kept = append(kept, fix)
+			continue
+		}
+		o := Outlier{Fix: fix, Reason: bad[i]}
+		if lim.Repair {
+			if a, b, ok := neighbours(fixes, bad, i); ok {
+				f := fix.Time.Sub(a.Time).Seconds() / b.Time.Sub(a.Time).Seconds()
+				fix.Lat = a.Lat + f*(b.Lat-a.Lat)
+				fix.Lon = a.Lon + f*(b.Lon-a.Lon)
+				fix.RMC, fix.GGA = nil, nil
+				fix.Repaired = true
+				kept = append(kept, fix)
+				o.Repaired = true
+			}
+		}
+		outliers = append(outliers, o)
+	}
+	return kept, outliers
+}
+//}}}
+//{{{  neighbours(fixes,bad,i) -- the good fixes either side of i
+// ok is false at either end, or if the two share a time.
+func neighbours(fixes []nb.Fix, bad []string, i int) (a, b *nb.Fix, ok bool) {
+	for k := i - 1; k >= 0 && a == nil; k-- {
+		if bad[k] == "" {
+			a = &fixes[k]
+		}
+	}
+	for k := i + 1; k < len(fixes) && b == nil; k++ {
+		if bad[k] == "" {
+			b = &fixes[k]
+		}
+	}
+	if a == nil || b == nil || !b.Time.After(a.Time) {
+		return nil, nil, false
+	}
+	return a, b, true
+}
+//}}}
@@ -0,0 +1,106 @@
+//{{{  License
+// Copyright 2018,2019 A E Lawrence
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//}}}
+
+package track
+
+//{{{  imports
+import (
+	"github.com/clarified/mov2gps/go/nb"
+	"math"
+)
+//}}}
+
+const (
+	earthRadius	= 6371008.8	// mean radius, metres, as nb.Distance
+	rad		= math.Pi / 180
+)
+
+//{{{  type plane -- flat metres east and north of a point
+// Flat enough over any drive: the error grows with the square of the
+// distance, and is under a metre at 50km.
+type plane struct {
+	lat0, lon0	float64
+	north, east	float64	// metres per degree
+}
+
+func newPlane(lat0, lon0 float64) plane {
+	north := earthRadius * rad
+	return plane{lat0: lat0, lon0: lon0, north: north,
+		east: north * math.Cos(lat0*rad)}
+}
+//}}}
+//{{{  Method xy(fix) -- where fix is on the plane
+func (p plane) xy(fix *nb.Fix) (x, y float64) {
+	return (fix.Lon - p.lon0) * p.east, (fix.Lat - p.lat0) * p.north
+}
+//}}}
+//{{{  Method latLon(x,y) -- back from the plane
+func (p plane) latLon(x, y float64) (lat, lon float64) {
+	return p.lat0 + y/p.north, p.lon0 + x/p.east
+}
+//}}}
+//{{{  Vincenty(lat1,lon1,lat2,lon2) -- geodesic metres on the WGS84 ellipsoid
+// Vincenty's inverse formula, good to a millimetre or so, where
+// nb.Distance on a sphere may be out by a few parts in a thousand: enough
+// to notice over a long trip. For nearly antipodal points, where the
+// iteration may not converge, it falls back on nb.Distance.
+func Vincenty(lat1, lon1, lat2, lon2 float64) float64 {
+	const (
+		a	= 6378137.0
+		f	= 1 / 298.257223563
+		b	= a * (1 - f)
+	)
+	if lat1 == lat2 && lon1 == lon2 {
+		return 0
+	}
+	l := (lon2 - lon1) * rad
+	u1 := math.Atan((1 - f) * math.Tan(lat1*rad))
+	u2 := math.Atan((1 - f) * math.Tan(lat2*rad))
+	sinU1, cosU1 := math.Sincos(u1)
+	sinU2, cosU2 := math.Sincos(u2)
+	lambda := l
+	for iter := 0; iter < 200; iter++ {
+		sinL, cosL := math.Sincos(lambda)
+		sinSigma := math.Hypot(cosU2*sinL, cosU1*sinU2-sinU1*cosU2*cosL)
+		if sinSigma == 0 {
+			return 0
+		}
+		cosSigma := sinU1*sinU2 + cosU1*cosU2*cosL
+		sigma := math.Atan2(sinSigma, cosSigma)
+		sinAlpha := cosU1 * cosU2 * sinL / sinSigma
+		cos2Alpha := 1 - sinAlpha*sinAlpha
+		cos2SigmaM := 0.0	// on the equator
+		if cos2Alpha != 0 {
+			cos2SigmaM = cosSigma - 2*sinU1*sinU2/cos2Alpha
+		}
+		c := f / 16 * cos2Alpha * (4 + f*(4-3*cos2Alpha))
+		prev := lambda
+		lambda = l + (1-c)*f*sinAlpha*(sigma+c*sinSigma*
+			(cos2SigmaM+c*cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)))
+		if math.Abs(lambda-prev) < 1e-12 {
+			uSq := cos2Alpha * (a*a - b*b) / (b * b)
+			bigA := 1 + uSq/16384*(4096+uSq*(-768+uSq*(320-175*uSq)))
+			bigB := uSq / 1024 * (256 + uSq*(-128+uSq*(74-47*uSq)))
+			deltaSigma := bigB * sinSigma * (cos2SigmaM + bigB/4*
+				(cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)-
+					bigB/6*cos2SigmaM*(-3+4*sinSigma*sinSigma)*
+						(-3+4*cos2SigmaM*cos2SigmaM)))
+			return b * bigA * (sigma - deltaSigma)
+		}
+	}
+	return nb.Distance(lat1, lon1, lat2, lon2)
+}
+//}}}
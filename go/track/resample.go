@@ -0,0 +1,141 @@
+//{{{  License
+// Copyright 2018,2019 A E Lawrence
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//}}}
+
+package track
+
+//{{{  imports
+import (
+	"github.com/clarified/mov2gps/go/nb"
+	"math"
+	"time"
+)
+//}}}
+
+//{{{  Resampling -- overview
+// Overlays and tagging want a point for each video frame, or some fixed
+// step, rather than whenever the receiver gave one. Resample puts points
+// at every multiple of a step of media time, so they line up with the
+// frames, interpolating between the fixes either side. Speed and time are
+// interpolated linearly, and course the short way round the compass.
+// Position either linearly in latitude and longitude, which is all 1 Hz
+// needs, or along the great circle, for fixes far apart.
+// The status comes from the nearer fix. The sentences and block offset
+// belong to that fix alone, so are dropped, and the point is marked
+// Interpolated, and as made up, repaired or smoothed as either fix is.
+// A grid time which falls on a fix keeps the fix itself.
+// Downsample just keeps the first fix in each step of GPS time.
+//}}}
+
+//{{{  type Interpolation -- of position
+type Interpolation int
+
+const (
+	Linear		Interpolation = iota
+	GreatCircle
+)
+//}}}
+
+//{{{  slerp(a,b,f) -- lat,lon a fraction f along the great circle a to b
+func slerp(a, b *nb.Fix, f float64) (lat, lon float64) {
+	unit := func(lat, lon float64) [3]float64 {
+		return [3]float64{math.Cos(lat*rad) * math.Cos(lon*rad),
+			math.Cos(lat*rad) * math.Sin(lon*rad), math.Sin(lat*rad)}
+	}
+	p, q := unit(a.Lat, a.Lon), unit(b.Lat, b.Lon)
+	omega := math.Acos(math.Max(-1, math.Min(1, p[0]*q[0]+p[1]*q[1]+p[2]*q[2])))
+	if omega < 1e-12 {
+		return a.Lat + f*(b.Lat-a.Lat), a.Lon + f*(b.Lon-a.Lon)
+	}
+	s, t := math.Sin((1-f)*omega)/math.Sin(omega), math.Sin(f*omega)/math.Sin(omega)
+	var v [3]float64
+	for i := range v {
+		v[i] = s*p[i] + t*q[i]
+	}
+	return math.Atan2(v[2], math.Hypot(v[0], v[1])) / rad, math.Atan2(v[1], v[0]) / rad
+}
+//}}}
+//{{{  interpolate(a,b,f,how) -- the fix a fraction f from a to b
+func interpolate(a, b *nb.Fix, f float64, how Interpolation) nb.Fix {
+	fix := *a
+	if f >= 0.5 {
+		fix = *b
+	}
+	fix.Time = a.Time.Add(time.Duration(f * float64(b.Time.Sub(a.Time))))
+	fix.Media = a.Media + time.Duration(f*float64(b.Media-a.Media))
+	switch how {
+	case GreatCircle	: fix.Lat, fix.Lon = slerp(a, b, f)
+	default			: fix.Lat, fix.Lon = a.Lat+f*(b.Lat-a.Lat), a.Lon+f*(b.Lon-a.Lon)
+	}
+	fix.Speed = a.Speed + f*(b.Speed-a.Speed)
+	fix.Course = nb.LerpAngle(a.Course, b.Course, f)
+	fix.Offset = -1
+	fix.RMC, fix.GGA = nil, nil
+	fix.Constellation, fix.FromRMC, fix.Precise = "", false, false
+	fix.Interpolated = true
+	fix.Synthetic = a.Synthetic || b.Synthetic
+	fix.Repaired = a.Repaired || b.Repaired
+	fix.Smoothed = a.Smoothed || b.Smoothed
+	return fix
+}
+//}}}
+//{{{  Resample(fixes,step,how) -- a fix at each multiple of step of media time
+// fixes must be in Media order, as nb Fixes returns them. Only times
+// between the first and last fix are covered: nothing is extrapolated.
+func Resample(fixes []nb.Fix, step time.Duration, how Interpolation) []nb.Fix {
+	if len(fixes) == 0 || step <= 0 {
+		return append(fixes[:0:0], fixes...)
+	}
+	first, last := fixes[0].Media, fixes[len(fixes)-1].Media
+	var out []nb.Fix
+	k := 0
+	t := (first + step - 1) / step * step
+	if first < 0 {
+		t = first / step * step
+	}
+	for ; t <= last; t += step {
+		for k+1 < len(fixes) && fixes[k+1].Media <= t {
+			k++
+		}
+		a := &fixes[k]
+		if a.Media == t || k+1 == len(fixes) {
+			out = append(out, *a)
+			continue
+		}
+		b := &fixes[k+1]
+		f := float64(t-a.Media) / float64(b.Media-a.Media)
+		out = append(out, interpolate(a, b, f, how))
+	}
+	return out
+}
+//}}}
+//{{{  Downsample(fixes,step) -- the first fix in each step of GPS time
+// Steps are counted from the first fix. fixes should be in time order.
+func Downsample(fixes []nb.Fix, step time.Duration) []nb.Fix {
+	kept := fixes[:0:0]
+	if len(fixes) == 0 || step <= 0 {
+		return append(kept, fixes...)
+	}
+	t0 := fixes[0].Time
+	last := int64(-1)
+	for _, fix := range fixes {
+		if n := int64(fix.Time.Sub(t0) / step); n > last {
+			kept = append(kept, fix)
+			last = n
+		}
+	}
+	return kept
+}
+//}}}
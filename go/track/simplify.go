@@ -0,0 +1,206 @@
+//{{{  License
+// Copyright 2018,2019 A E Lawrence
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//}}}
+
+package track
+
+//{{{  imports
+import (
+	"container/heap"
+	"github.com/clarified/mov2gps/go/nb"
+	"math"
+)
+//}}}
+
+//{{{  Simplification -- overview
+// A day at 1 Hz is tens of thousands of points, most of them on straight
+// roads where the points between two others add nothing to a map.
+// Simplify leaves out the points which are within a tolerance of the line
+// through those kept, by one of two methods:
+// Douglas-Peucker keeps the point furthest from the line between two
+// kept points, if further than the tolerance, and splits there until
+// nothing is. Visvalingam-Whyatt repeatedly leaves out the least
+// significant point, until none is less than the tolerance. Its
+// significance is usually the area of the triangle with its neighbours;
+// here it is that triangle's height, the distance from the point to the
+// line joining its neighbours, so that the tolerance is in metres for
+// both methods. It tends to keep the shape of bends better.
+// Points kept are unchanged, time included. The first and last are always
+// kept, as are those where the vehicle stops or moves off, so that stops
+// keep their times.
+//}}}
+const stopSpeed = 1.0	// m/s: reported speed below this is stopped
+
+//{{{  type Method -- how to simplify
+type Method int
+
+const (
+	DouglasPeucker	Method = iota
+	Visvalingam
+)
+//}}}
+
+//{{{  anchors(fixes) -- the points which must be kept
+func anchors(fixes []nb.Fix) []bool {
+	keep := make([]bool, len(fixes))
+	keep[0], keep[len(keep)-1] = true, true
+	for i := 1; i < len(fixes); i++ {
+		if (fixes[i].Speed < stopSpeed) != (fixes[i-1].Speed < stopSpeed) {
+			keep[i-1], keep[i] = true, true
+		}
+	}
+	return keep
+}
+//}}}
+//{{{  type point -- a fix on the plane
+type point struct {
+	x, y	float64
+}
+
+func project(fixes []nb.Fix) []point {
+	pl := newPlane(fixes[0].Lat, fixes[0].Lon)
+	pts := make([]point, len(fixes))
+	for i := range fixes {
+		pts[i].x, pts[i].y = pl.xy(&fixes[i])
+	}
+	return pts
+}
+//}}}
+//{{{  offLine(p,a,b) -- metres from p to the segment ab
+func offLine(p, a, b point) float64 {
+	dx, dy := b.x-a.x, b.y-a.y
+	t := 0.0
+	if d2 := dx*dx + dy*dy; d2 > 0 {
+		t = math.Max(0, math.Min(1, ((p.x-a.x)*dx+(p.y-a.y)*dy)/d2))
+	}
+	return math.Hypot(p.x-a.x-t*dx, p.y-a.y-t*dy)
+}
+//}}}
+
+//{{{  Simplify(fixes,tolerance,method) -- fewer fixes, the same shape
+// tolerance is in metres. fixes are left alone.
+func Simplify(fixes []nb.Fix, tolerance float64, method Method) []nb.Fix {
+	if len(fixes) < 3 || tolerance <= 0 {
+		return append(fixes[:0:0], fixes...)
+	}
+	keep := anchors(fixes)
+	pts := project(fixes)
+	switch method {
+	case Visvalingam	: visvalingam(pts, keep, tolerance)
+	default			: douglasPeucker(pts, keep, tolerance)
+	}
+	kept := fixes[:0:0]
+	for i, fix := range fixes {
+		if keep[i] {
+			kept = append(kept, fix)
+		}
+	}
+	return kept
+}
+//}}}
+//{{{  douglasPeucker(pts,keep,tolerance) -- mark the points to keep
+// Between each pair of anchors in turn: those are kept anyway.
+func douglasPeucker(pts []point, keep []bool, tolerance float64) {
+	type span struct{ a, b int }
+	var todo []span
+	a := 0
+	for b := 1; b < len(pts); b++ {
+		if keep[b] {
+			todo = append(todo, span{a, b})
+			a = b
+		}
+	}
+	for len(todo) > 0 {
+		s := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+		far, worst := -1, tolerance
+		for i := s.a + 1; i < s.b; i++ {
+			if d := offLine(pts[i], pts[s.a], pts[s.b]); d > worst {
+				far, worst = i, d
+			}
+		}
+		if far >= 0 {
+			keep[far] = true
+			todo = append(todo, span{s.a, far}, span{far, s.b})
+		}
+	}
+}
+//}}}
+//{{{  Visvalingam-Whyatt -- a heap of triangles
+// Triangles are not removed from the heap as their neighbours go: they
+// are recognised as stale when popped, by their height no longer matching.
+type triangle struct {
+	i	int
+	height	float64
+}
+
+type triangles []triangle
+
+func (t triangles) Len() int			{ return len(t) }
+func (t triangles) Less(i, j int) bool		{ return t[i].height < t[j].height }
+func (t triangles) Swap(i, j int)		{ t[i], t[j] = t[j], t[i] }
+func (t *triangles) Push(x interface{})		{ *t = append(*t, x.(triangle)) }
+func (t *triangles) Pop() interface{} {
+	old := *t
+	x := old[len(old)-1]
+	*t = old[:len(old)-1]
+	return x
+}
+//}}}
+//{{{  visvalingam(pts,keep,tolerance) -- mark the points to keep
+func visvalingam(pts []point, keep []bool, tolerance float64) {
+	n := len(pts)
+	prev, next := make([]int, n), make([]int, n)
+	heights := make([]float64, n)
+	gone := make([]bool, n)
+	h := &triangles{}
+	//{{{  Every point starts in, between its neighbours
+	for i := range pts {
+		prev[i], next[i] = i-1, i+1
+	}
+	for i := 1; i < n-1; i++ {
+		if !keep[i] {
+			heights[i] = offLine(pts[i], pts[i-1], pts[i+1])
+			*h = append(*h, triangle{i, heights[i]})
+		}
+	}
+	heap.Init(h)
+	//}}}
+	for h.Len() > 0 {
+		t := heap.Pop(h).(triangle)
+		if gone[t.i] || t.height != heights[t.i] {
+			continue
+		}
+		if t.height >= tolerance {
+			break
+		}
+		gone[t.i] = true
+		p, q := prev[t.i], next[t.i]
+		next[p], prev[q] = q, p
+		//{{{  The neighbours now make new triangles
+		for _, k := range []int{p, q} {
+			if keep[k] {
+				continue
+			}
+			heights[k] = offLine(pts[k], pts[prev[k]], pts[next[k]])
+			heap.Push(h, triangle{k, heights[k]})
+		}
+		//}}}
+	}
+	for i := range keep {
+		keep[i] = keep[i] || !gone[i]
+	}
+}
+//}}}
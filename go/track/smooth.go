@@ -0,0 +1,230 @@
+//{{{  License
+// Copyright 2018,2019 A E Lawrence
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//}}}
+
+package track
+
+//{{{  imports
+import (
+	"github.com/clarified/mov2gps/go/nb"
+	"math"
+)
+//}}}
+
+//{{{  Smoothing -- overview
+// Among buildings the fixes wander a few metres either side of the road,
+// and the track zig-zags. Smooth runs a Kalman filter forward over the
+// fixes, then a Rauch-Tung-Striebel smoother back, so that each position
+// is estimated from the whole track, before and after it.
+// The model is a vehicle moving at constant velocity, disturbed by random
+// acceleration. It works in metres east and north of the first fix, which
+// is flat enough over any drive. Each fix is observed as a position, good
+// to UERE times its hdop, and as a velocity from its speed and course.
+// The velocity is what pulls a zig-zag straight: the receiver measures it
+// from Doppler, independently of, and better than, the position.
+//}}}
+const (
+	defaultHDOP	= 2.0	// when the fix has none
+	unknown		= 1e8	// initial variance: nothing known
+)
+
+//{{{  type Smoothing -- the noise in the model
+type Smoothing struct {
+	Accel		float64	// m/s², standard deviation of acceleration
+	UERE		float64	// m, position error at hdop 1
+	SpeedError	float64	// m/s, standard deviation of reported velocity
+}
+//}}}
+//{{{  DefaultSmoothing() -- for a car and a consumer receiver
+func DefaultSmoothing() Smoothing {
+	return Smoothing{Accel: 2, UERE: 4, SpeedError: 1}
+}
+//}}}
+
+//{{{  type vec, mat -- state x, y, vx, vy and its covariance
+type vec [4]float64
+type mat [4][4]float64
+
+func (a mat) mul(b mat) (c mat) {
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			for k := 0; k < 4; k++ {
+				c[i][j] += a[i][k] * b[k][j]
+			}
+		}
+	}
+	return c
+}
+
+func (a mat) apply(v vec) (w vec) {
+	for i := 0; i < 4; i++ {
+		for k := 0; k < 4; k++ {
+			w[i] += a[i][k] * v[k]
+		}
+	}
+	return w
+}
+
+func (a mat) transpose() (t mat) {
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			t[i][j] = a[j][i]
+		}
+	}
+	return t
+}
+//}}}
+//{{{  Method inverse -- Gauss-Jordan, ok false if singular
+func (a mat) inverse() (inv mat, ok bool) {
+	for i := 0; i < 4; i++ {
+		inv[i][i] = 1
+	}
+	for c := 0; c < 4; c++ {
+		p := c
+		for r := c + 1; r < 4; r++ {
+			if math.Abs(a[r][c]) > math.Abs(a[p][c]) {
+				p = r
+			}
+		}
+		if a[p][c] == 0 {
+			return inv, false
+		}
+		a[c], a[p] = a[p], a[c]
+		inv[c], inv[p] = inv[p], inv[c]
+		d := a[c][c]
+		for j := 0; j < 4; j++ {
+			a[c][j] /= d
+			inv[c][j] /= d
+		}
+		for r := 0; r < 4; r++ {
+			if r == c || a[r][c] == 0 {
+				continue
+			}
+			f := a[r][c]
+			for j := 0; j < 4; j++ {
+				a[r][j] -= f * a[c][j]
+				inv[r][j] -= f * inv[c][j]
+			}
+		}
+	}
+	return inv, true
+}
+//}}}
+//{{{  transition(dt) -- constant velocity over dt seconds
+func transition(dt float64) mat {
+	return mat{{1, 0, dt, 0}, {0, 1, 0, dt}, {0, 0, 1, 0}, {0, 0, 0, 1}}
+}
+//}}}
+//{{{  Method predict(x,p,dt) -- state and covariance dt later
+func (s Smoothing) predict(x vec, p mat, dt float64) (vec, mat) {
+	f := transition(dt)
+	p = f.mul(p).mul(f.transpose())
+	//{{{  Random acceleration, the same each way
+	q := s.Accel * s.Accel
+	for i := 0; i < 2; i++ {
+		p[i][i] += q * dt * dt * dt * dt / 4
+		p[i][i+2] += q * dt * dt * dt / 2
+		p[i+2][i] += q * dt * dt * dt / 2
+		p[i+2][i+2] += q * dt * dt
+	}
+	//}}}
+	return f.apply(x), p
+}
+//}}}
+//{{{  observe(x,p,i,z,r) -- update by a measurement z of state i, variance r
+// The measurements are independent, so are taken one at a time.
+func observe(x vec, p mat, i int, z, r float64) (vec, mat) {
+	s := p[i][i] + r
+	var k vec
+	for j := 0; j < 4; j++ {
+		k[j] = p[j][i] / s
+	}
+	y := z - x[i]
+	row := p[i]
+	for j := 0; j < 4; j++ {
+		x[j] += k[j] * y
+		for m := 0; m < 4; m++ {
+			p[j][m] -= k[j] * row[m]
+		}
+	}
+	return x, p
+}
+//}}}
+//{{{  Smooth(fixes,s) -- fixes with smoothed positions
+// Fixes should be in time order, and cleaned of 0/0 points. Times and
+// every other field are kept: only Lat and Lon change, and Smoothed is
+// set. A void fix is still used for its position, but not its velocity.
+func Smooth(fixes []nb.Fix, s Smoothing) []nb.Fix {
+	out := append(fixes[:0:0], fixes...)
+	n := len(fixes)
+	if n < 2 {
+		return out
+	}
+	pl := newPlane(fixes[0].Lat, fixes[0].Lon)
+	//{{{  Forward: Kalman filter, keeping each prediction
+	xp, xf := make([]vec, n), make([]vec, n)
+	pp, pf := make([]mat, n), make([]mat, n)
+	dts := make([]float64, n)
+	x := vec{}
+	p := mat{{unknown, 0, 0, 0}, {0, unknown, 0, 0}, {0, 0, unknown, 0}, {0, 0, 0, unknown}}
+	for k := range fixes {
+		fix := &fixes[k]
+		if k > 0 {
+			dts[k] = math.Max(0, fix.Time.Sub(fixes[k-1].Time).Seconds())
+		}
+		x, p = s.predict(x, p, dts[k])
+		xp[k], pp[k] = x, p
+		h, ok := fix.HDOP()
+		if !ok {
+			h = defaultHDOP
+		}
+		r := (s.UERE * h) * (s.UERE * h)
+		east, north := pl.xy(fix)
+		x, p = observe(x, p, 0, east, r)
+		x, p = observe(x, p, 1, north, r)
+		if fix.Status != 'V' {
+			c := fix.Course * rad
+			v := s.SpeedError * s.SpeedError
+			x, p = observe(x, p, 2, fix.Speed*math.Sin(c), v)
+			x, p = observe(x, p, 3, fix.Speed*math.Cos(c), v)
+		}
+		xf[k], pf[k] = x, p
+	}
+	//}}}
+	//{{{  Back: RTS smoother
+	xs := xf[n-1]
+	for k := n - 1; k >= 0; k-- {
+		if k < n-1 {
+			if inv, ok := pp[k+1].inverse(); ok {
+				c := pf[k].mul(transition(dts[k+1]).transpose()).mul(inv)
+				var d vec
+				for j := range d {
+					d[j] = xs[j] - xp[k+1][j]
+				}
+				d = c.apply(d)
+				for j := range xs {
+					xs[j] = xf[k][j] + d[j]
+				}
+			} else {
+				xs = xf[k]
+			}
+		}
+		out[k].Lat, out[k].Lon = pl.latLon(xs[0], xs[1])
+		out[k].Smoothed = true
+	}
+	//}}}
+	return out
+}
+//}}}
@@ -0,0 +1,47 @@
+//{{{  License
+// Copyright 2018,2019 A E Lawrence
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//}}}
+
+package track
+
+//{{{  imports
+import (
+	"github.com/clarified/mov2gps/go/nb"
+	"time"
+)
+//}}}
+
+//{{{  Split(fixes,gap,jump) -- segments broken where time or place jumps
+// A new segment starts where the time since the fix before is more than
+// gap, or the distance from it more than jump metres, so that maps don't
+// draw a straight line across a tunnel or a pause. Either test is off when
+// zero. The segments are slices of fixes, in order, and never empty.
+func Split(fixes []nb.Fix, gap time.Duration, jump float64) [][]nb.Fix {
+	var segments [][]nb.Fix
+	start := 0
+	for i := 1; i <= len(fixes); i++ {
+		if i < len(fixes) {
+			a, b := &fixes[i-1], &fixes[i]
+			if !(gap > 0 && b.Time.Sub(a.Time) > gap) &&
+				!(jump > 0 && nb.Distance(a.Lat, a.Lon, b.Lat, b.Lon) > jump) {
+				continue
+			}
+		}
+		segments = append(segments, fixes[start:i])
+		start = i
+	}
+	return segments
+}
+//}}}
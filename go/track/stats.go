@@ -0,0 +1,122 @@
+//{{{  License
+// Copyright 2018,2019 A E Lawrence
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//}}}
+
+package track
+
+//{{{  imports
+import (
+	"github.com/clarified/mov2gps/go/nb"
+	"github.com/clarified/mov2gps/go/nmea"
+	"math"
+	"time"
+)
+//}}}
+
+//{{{  Trip statistics -- overview
+// Each interval between fixes counts as moving or stopped. Normally the
+// speed the receiver reports decides, since positions wander at a stop;
+// across a gap longer than statsGap, with nothing reported in between,
+// the distance covered does. Distance is only added up while moving, so
+// the wander at stops doesn't count, and is geodesic: see Vincenty.
+// Speeds are those which decide: the receiver's, or distance over time
+// across a gap, so that the average, weighted by time moving, can't
+// exceed the maximum.
+// Elevation is from GGA, and only changes of more than climbStep from the
+// last counted are added, so that the receiver's noise, which is several
+// metres, doesn't pile up into climbs that never happened.
+//}}}
+const (
+	statsGap	= 10 * time.Second
+	climbStep	= 5.0	// metres
+)
+
+//{{{  type Stats -- summary of a track
+// Speeds in m/s, distance and elevation in metres. Average speed is over
+// the moving time. Elevation is false if no fix had one.
+type Stats struct {
+	Start, End	time.Time
+	Points		int
+	Distance	float64
+	Moving		time.Duration
+	Stopped		time.Duration
+	AvgSpeed	float64
+	MaxSpeed	float64
+	Elevation	bool
+	Gain, Loss	float64
+}
+//}}}
+//{{{  altitude(fix) -- metres above sea level, ok false if unknown
+func altitude(fix *nb.Fix) (float64, bool) {
+	if fix.GGA == nil || !fix.GGA.Valid(nmea.GGAAltitude) {
+		return 0, false
+	}
+	return fix.GGA.Altitude, true
+}
+//}}}
+//{{{  Summarise(fixes) -- Stats for fixes in time order
+func Summarise(fixes []nb.Fix) Stats {
+	var s Stats
+	if len(fixes) == 0 {
+		return s
+	}
+	s.Start, s.End, s.Points = fixes[0].Time, fixes[len(fixes)-1].Time, len(fixes)
+	var ref float64		// elevation last counted
+	var run float64		// metres at the speeds counted while moving
+	for i := range fixes {
+		b := &fixes[i]
+		s.MaxSpeed = math.Max(s.MaxSpeed, b.Speed)
+		//{{{  Elevation, with hysteresis
+		if alt, ok := altitude(b); ok {
+			switch {
+			case !s.Elevation:
+				s.Elevation, ref = true, alt
+			case alt-ref > climbStep:
+				s.Gain += alt - ref
+				ref = alt
+			case ref-alt > climbStep:
+				s.Loss += ref - alt
+				ref = alt
+			}
+		}
+		//}}}
+		if i == 0 {
+			continue
+		}
+		a := &fixes[i-1]
+		dt := b.Time.Sub(a.Time)
+		if dt <= 0 {
+			continue
+		}
+		d := Vincenty(a.Lat, a.Lon, b.Lat, b.Lon)
+		v := (a.Speed + b.Speed) / 2
+		if dt > statsGap {
+			v = d / dt.Seconds()
+			s.MaxSpeed = math.Max(s.MaxSpeed, v)
+		}
+		if v < stopSpeed {
+			s.Stopped += dt
+			continue
+		}
+		s.Moving += dt
+		s.Distance += d
+		run += v * dt.Seconds()
+	}
+	if s.Moving > 0 {
+		s.AvgSpeed = run / s.Moving.Seconds()
+	}
+	return s
+}
+//}}}
@@ -0,0 +1,447 @@
+//{{{  license
+// Copyright 2018,2019 A E Lawrence
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//}}}
+
+// verify looks for signs that a dashcam MOV file is no longer the camera
+// original: cut, joined, re-encoded or just re-muxed by some other program.
+// The camera writes its files in a very regular way, and editors rarely
+// keep that, nor the GPS blocks which only the camera understands.
+// Exit status is 0 if no file shows any sign, 1 if any does.
+package main
+
+//{{{  imports
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/clarified/mov2gps/go/mov"
+	"github.com/clarified/mov2gps/go/nb"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+//}}}
+//{{{  flags
+var (
+	quiet	= flag.Bool("q", false, "Only report the signs found, not the checks passed")
+	debug	= flag.Bool("debug", false, "tracing to stderr")
+)
+//}}}
+//{{{  usage
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: verify [-q] file.MOV ...")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+//}}}
+
+//{{{  main
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+	}
+	nb.SetDebug(*debug)
+	signs := 0
+	for i := 0; i < flag.NArg(); i++ {
+		signs += verify(flag.Arg(i))
+	}
+	if signs > 0 {
+		os.Exit(1)
+	}
+}
+//}}}
+
+//{{{  type atom -- a top level atom, read directly
+type atom struct {
+	Type	string
+	Offset	int64
+	Size	int64
+}
+//}}}
+//{{{  topAtoms(f,size) -- the top level atoms, in file order
+// Read here rather than through mov, so that nothing is assumed. A size of
+// 0 runs to the end of the file, 1 means a 64 bit size follows, which
+// can't be less than the 16 byte header. An atom running past the end of
+// the file is cut short.
+func topAtoms(f io.ReaderAt, size int64) ([]atom, error) {
+	var atoms []atom
+	for off := int64(0); off+8 <= size; {
+		var hdr [16]byte
+		if _, err := f.ReadAt(hdr[:8], off); err != nil {
+			return nil, err
+		}
+		a := atom{Type: string(hdr[4:8]), Offset: off,
+			Size: int64(binary.BigEndian.Uint32(hdr[:4]))}
+		switch a.Size {
+		case 0:
+			a.Size = size - off
+		case 1:
+			if _, err := f.ReadAt(hdr[8:16], off+8); err != nil {
+				return nil, err
+			}
+			a.Size = int64(binary.BigEndian.Uint64(hdr[8:16]))
+			if a.Size < 16 {
+				return nil, errors.New(fmt.Sprintf("%q at %#x: 64 bit size %d is less than its header",
+					a.Type, off, a.Size))
+			}
+		}
+		if a.Size < 8 || off+a.Size > size {
+			a.Size = size - off
+		}
+		atoms = append(atoms, a)
+		off += a.Size
+	}
+	return atoms, nil
+}
+//}}}
+//{{{  type report -- collects the result of each check
+type report struct {
+	signs	int
+}
+
+func (r *report) ok(check, format string, a ...interface{}) {
+	if !*quiet {
+		fmt.Printf("  ok    %-12s %s\n", check, fmt.Sprintf(format, a...))
+	}
+}
+
+func (r *report) sign(check, format string, a ...interface{}) {
+	r.signs++
+	fmt.Printf("  SIGN  %-12s %s\n", check, fmt.Sprintf(format, a...))
+}
+//}}}
+
+//{{{  verify(movPath) -- run the checks, return the number of signs
+// A file which can't be read, or read far enough to finish the checks,
+// is itself a sign: the camera writes files which can.
+func verify(movPath string) int {
+	fmt.Println(movPath)
+	r := &report{}
+	if err := checks(r, movPath); err != nil {
+		r.sign("read", "%v: checks stopped", err)
+	}
+	switch r.signs {
+	case 0	: fmt.Println("  no signs of editing")
+	case 1	: fmt.Println("  1 sign of editing")
+	default	: fmt.Printf("  %d signs of editing\n", r.signs)
+	}
+	return r.signs
+}
+//}}}
+//{{{  checks(r,movPath) -- each check in turn, until one can't read on
+func checks(r *report, movPath string) error {
+	f, err := os.Open(movPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	atoms, err := topAtoms(f, st.Size())
+	if err != nil {
+		return err
+	}
+	checkFtyp(r, f, atoms)
+	mdat := checkOrder(r, atoms)
+	info := nb.NewInfo(f)
+	if _, udata, err := info.GPSLogs(); err != nil {
+		return err
+	} else {
+		checkUdta(r, f, udata)
+	}
+	fixes, _, err := info.Fixes()
+	if err != nil {
+		return err
+	}
+	checkBlocks(r, info, fixes, mdat)
+	if err = checkSpacing(r, f, info); err != nil {
+		return err
+	}
+	checkTimeline(r, fixes)
+	return checkDuration(r, f, fixes)
+}
+//}}}
+//{{{  checkFtyp(r,f,atoms) -- is it a Quicktime file, as the camera writes?
+// The cameras write major brand "qt  ". Editors and muxers usually write
+// an mp4 brand (isom, mp42...) even when keeping the .MOV name.
+func checkFtyp(r *report, f io.ReaderAt, atoms []atom) {
+	if len(atoms) == 0 || atoms[0].Type != "ftyp" {
+		r.sign("ftyp", "file does not start with ftyp")
+		return
+	}
+	brand := make([]byte, 4)
+	if _, err := f.ReadAt(brand, atoms[0].Offset+8); err != nil {
+		r.sign("ftyp", "ftyp too short for a brand")
+		return
+	}
+	if string(brand) != "qt  " {
+		r.sign("ftyp", "major brand %q, not the camera's \"qt  \"", brand)
+		return
+	}
+	r.ok("ftyp", "major brand %q", brand)
+}
+//}}}
+//{{{  checkOrder(r,atoms) -- ftyp, mdat, moov and nothing unexpected
+// The camera streams mdat and writes moov when the clip closes, so moov
+// comes last. Moving moov to the front ("fast start") is one of the first
+// things an editor or muxer does. Returns mdat, zero if none.
+func checkOrder(r *report, atoms []atom) (mdat atom) {
+	var types []string
+	moovAt, mdatAt := -1, -1
+	var odd []string
+	for i, a := range atoms {
+		types = append(types, a.Type)
+		switch a.Type {
+		case "moov"			: moovAt = i
+		case "mdat"			: mdatAt, mdat = i, a
+		case "ftyp", "free", "skip", "wide":
+		default				: odd = append(odd, a.Type)
+		}
+	}
+	order := strings.Join(types, " ")
+	switch {
+	case mdatAt < 0:
+		r.sign("atom order", "%s: no mdat", order)
+	case moovAt < 0:
+		r.sign("atom order", "%s: no moov (unfinished recording?)", order)
+	case moovAt < mdatAt:
+		r.sign("atom order", "%s: moov before mdat, rewritten", order)
+	case len(odd) > 0:
+		r.sign("atom order", "%s: unexpected %v", order, odd)
+	default:
+		r.ok("atom order", "%s", order)
+	}
+	return mdat
+}
+//}}}
+//{{{  checkUdta(r,f,udata) -- camera strings present, no encoder tag
+// Encoders leave their name in udta: ffmpeg and friends as ©too or
+// ©swr, sometimes inside meta/ilst. Looked for as raw bytes, since their
+// layout varies.
+func checkUdta(r *report, f mov.ReadAtSeeker, udata *nb.UserData) {
+	if len(udata.Inf) == 0 && len(udata.Fmt) == 0 {
+		r.sign("udta", "no camera model or firmware strings")
+	} else {
+		r.ok("udta", "%s %s", udata.Inf, udata.Fmt)
+	}
+	found, err := mov.Find(f, "moov/udta")
+	if err != nil || len(found) == 0 {
+		return
+	}
+	body := make([]byte, found[0].Size())
+	if _, err := found[0].ReadAt(body, 0); err != nil {
+		return
+	}
+	for _, tag := range []string{"\xa9too", "\xa9swr", "\xa9enc"} {
+		if i := bytes.Index(body, []byte(tag)); i >= 0 {
+			r.sign("encoder", "%q tag in udta: %q", tag[1:],
+				printable(body[i+4:]))
+			return
+		}
+	}
+	r.ok("encoder", "no encoder tag")
+}
+//}}}
+//{{{  printable(b) -- the first run of printable text in b
+func printable(b []byte) string {
+	start := bytes.IndexFunc(b, func(c rune) bool { return c >= ' ' && c < 0x7f })
+	if start < 0 {
+		return ""
+	}
+	b = b[start:]
+	if end := bytes.IndexFunc(b, func(c rune) bool { return c < ' ' || c >= 0x7f }); end >= 0 {
+		b = b[:end]
+	}
+	return string(b)
+}
+//}}}
+//{{{  checkBlocks(r,info,fixes,mdat) -- one GPS block per sound chunk
+// Every sound chunk has its GPS block, at the same distance after the
+// chunk, inside mdat. Cutting or re-muxing moves the chunks and loses or
+// reorders the blocks.
+func checkBlocks(r *report, info nb.GPSInfo, fixes []nb.Fix, mdat atom) {
+	if info.Scanned() {
+		r.sign("GPS blocks", "no sound chunks to find them by: found by scanning")
+		return
+	}
+	offsets := info.Offsets()
+	if len(offsets) == 0 {
+		r.sign("GPS blocks", "none")
+		return
+	}
+	if missing := info.Missing(); len(missing) > 0 {
+		r.sign("GPS blocks", "%d of %d missing, after sound chunks %v",
+			len(missing), len(offsets), missing)
+	} else {
+		r.ok("GPS blocks", "%d, one per sound chunk", len(offsets))
+	}
+	//{{{  Inside mdat and in file order
+	outside, backwards := 0, 0
+	last := int64(-1)
+	for _, off := range offsets {
+		if off < 0 {
+			continue
+		}
+		if off < mdat.Offset || off >= mdat.Offset+mdat.Size {
+			outside++
+		}
+		if off <= last {
+			backwards++
+		}
+		last = off
+	}
+	switch {
+	case outside > 0:
+		r.sign("chunk layout", "%d GPS blocks outside mdat", outside)
+	case backwards > 0:
+		r.sign("chunk layout", "%d GPS blocks out of file order", backwards)
+	default:
+		r.ok("chunk layout", "blocks inside mdat, in order")
+	}
+	//}}}
+	//{{{  GPS time in block order
+	reversed := 0
+	for i := 1; i < len(fixes); i++ {
+		if fixes[i].Time.Before(fixes[i-1].Time) {
+			reversed++
+		}
+	}
+	if reversed > 0 {
+		r.sign("block order", "GPS time goes back %d times", reversed)
+	} else {
+		r.ok("block order", "GPS time increasing")
+	}
+	//}}}
+}
+//}}}
+//{{{  checkSpacing(r,f,info) -- each block the same distance after its chunk
+// The camera pads every sound chunk alike, so each GPS block is the same
+// number of bytes after its chunk. A muxer writing the blocks back packs
+// them differently, or at varying distances.
+func checkSpacing(r *report, f mov.ReadAtSeeker, info nb.GPSInfo) error {
+	if info.Scanned() {
+		return nil
+	}
+	tracks, err := mov.Tracks(f)
+	if err != nil {
+		return err
+	}
+	sound := mov.FirstTrack(tracks, "soun")
+	if sound == nil {
+		return nil
+	}
+	chunks, err := sound.ChunkOffsets()
+	if err != nil {
+		return err
+	}
+	gaps := make(map[int64]int)
+	usual, most := int64(0), 0
+	for i, off := range info.Offsets() {
+		if off < 0 || i >= len(chunks) {
+			continue
+		}
+		gap := off - int64(chunks[i])
+		if gaps[gap]++; gaps[gap] > most {
+			usual, most = gap, gaps[gap]
+		}
+	}
+	switch {
+	case most == 0:
+	case len(gaps) > 1:
+		r.sign("spacing", "GPS blocks at %d different distances after their chunks, %d at +%#x",
+			len(gaps), most, usual)
+	default:
+		r.ok("spacing", "every GPS block at +%#x after its chunk", usual)
+	}
+	return nil
+}
+//}}}
+//{{{  step(fixes) -- the usual GPS time between fixes, zero if unknown
+func step(fixes []nb.Fix) time.Duration {
+	var dts []float64
+	for i := 1; i < len(fixes); i++ {
+		if dt := fixes[i].Time.Sub(fixes[i-1].Time); dt > 0 {
+			dts = append(dts, float64(dt))
+		}
+	}
+	if len(dts) == 0 {
+		return 0
+	}
+	sort.Float64s(dts)
+	return time.Duration(dts[len(dts)/2])
+}
+//}}}
+//{{{  checkTimeline(r,fixes) -- no jumps in GPS time, which keeps to media
+// A cut shows as a jump in GPS time between neighbouring blocks. Joining
+// or re-timing shows as GPS time drifting away from media time.
+func checkTimeline(r *report, fixes []nb.Fix) {
+	if len(fixes) < 2 {
+		return
+	}
+	usual := step(fixes)
+	gaps := 0
+	worst := 0.0
+	for i := 1; i < len(fixes); i++ {
+		a, b := fixes[i-1], fixes[i]
+		if usual > 0 && b.Time.Sub(a.Time) > 2*usual {
+			gaps++
+		}
+		drift := (b.Time.Sub(fixes[0].Time) - (b.Media - fixes[0].Media)).Seconds()
+		worst = math.Max(worst, math.Abs(drift))
+	}
+	if gaps > 0 {
+		r.sign("timeline", "%d gaps in GPS time, usual step %v", gaps, usual)
+	} else {
+		r.ok("timeline", "no gaps in GPS time, step %v", usual)
+	}
+	if worst > 1.5 {
+		r.sign("media time", "GPS time drifts %.1fs from media time", worst)
+	} else {
+		r.ok("media time", "GPS time keeps to media time (%.1fs)", worst)
+	}
+}
+//}}}
+//{{{  checkDuration(r,f,fixes) -- GPS span matches the movie
+// The camera writes one block per sound chunk for the whole clip, so the
+// GPS times span the movie duration, give or take a block.
+func checkDuration(r *report, f mov.ReadAtSeeker, fixes []nb.Fix) error {
+	movie, err := mov.ReadMovie(f)
+	if err == mov.ErrNoMovie || len(fixes) == 0 {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	span := fixes[len(fixes)-1].Time.Sub(fixes[0].Time) + step(fixes)
+	diff := span.Seconds() - movie.Seconds()
+	if math.Abs(diff) > 2 {
+		r.sign("duration", "GPS covers %.1fs, movie is %.1fs", span.Seconds(),
+			movie.Seconds())
+	} else {
+		r.ok("duration", "GPS covers %.1fs, movie is %.1fs", span.Seconds(),
+			movie.Seconds())
+	}
+	return nil
+}
+//}}}